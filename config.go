@@ -0,0 +1,127 @@
+/*
+ * Copyright 2024 National Library of Norway.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nlnwa/veidemann-metrics/internal/metrics"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// registerFlags defines the command line flags of the exporter.
+func registerFlags(fs *pflag.FlagSet) {
+	fs.String("host", "", "Host")
+	fs.Int("port", 9301, "Port")
+	fs.Duration("shutdown-timeout", 10*time.Second, "Time to wait for in-flight requests on shutdown")
+
+	fs.String("db-host", "rethinkdb-proxy", "Database host")
+	fs.Int("db-port", 28015, "Database port")
+	fs.String("db-name", "veidemann", "Database name")
+	fs.String("db-username", "admin", "Database username")
+	fs.String("db-password", "", "Database password")
+
+	fs.String("targets-file", "", "File listing Veidemann installations to serve at /probe?target=<name>")
+
+	fs.String("frontier-host", "veidemann-frontier", "Frontier host")
+	fs.Int("frontier-port", 7700, "Frontier port")
+
+	fs.String("log-level", "info", "Log level; available levels are panic, fatal, error, warn, info, debug and trace")
+	fs.String("log-formatter", "logfmt", "Log formatter; available values are logfmt and json")
+	fs.Bool("log-method", false, "Log method names or not")
+
+	fs.String("collect-mode", string(metrics.ModeBackground), "Default collector mode; available modes are background and scrape")
+	fs.Duration("collect-interval", 30*time.Second, "Default interval between collector updates")
+	fs.Duration("collect-timeout", 10*time.Second, "Default timeout of a collector update")
+	fs.Duration("cache-ttl", 5*time.Second, "Default time scrape mode and probe updates reuse the result of a collector")
+	for _, name := range metrics.Collectors() {
+		fs.Bool("collector."+name, metrics.DefaultEnabled(name), fmt.Sprintf("Enable the %s collector", name))
+		fs.Bool("no-collector."+name, false, fmt.Sprintf("Disable the %s collector", name))
+		fs.String(collectorKey(name, "mode"), "", fmt.Sprintf("Mode of the %s collector; defaults to --collect-mode", name))
+		fs.Duration(collectorKey(name, "interval"), 0, fmt.Sprintf("Interval between updates of the %s collector; defaults to --collect-interval", name))
+		fs.Duration(collectorKey(name, "timeout"), 0, fmt.Sprintf("Timeout of an update of the %s collector; defaults to --collect-timeout", name))
+		fs.Duration(collectorKey(name, "cache-ttl"), 0, fmt.Sprintf("Time the result of the %s collector is reused; defaults to --cache-ttl", name))
+	}
+
+	fs.Bool(collectorKey("jobs", "changefeed"), false, "Maintain job state of the jobs collector from RethinkDB changefeeds instead of querying on every update")
+	fs.Duration(collectorKey("job-outcomes", "window"), 0, "Only count job executions started within this duration; 0 counts the whole history")
+	fs.Int(collectorKey("crawl-executions", "top-n"), 10, "Number of crawl executions with the most uris crawled exported by seed for each running job execution")
+	fs.Duration(collectorKey("stuck-executions", "threshold"), time.Hour, "Time a fetching or sleeping crawl execution may go without changing before it is reported as stuck")
+	fs.Duration(collectorKey("job-progress", "stall-after"), time.Hour, "Time a running job execution may go without crawling before it is reported as stalled")
+}
+
+// collectorKey returns the key of a setting of the named collector. Settings
+// are not nested under the collector.<name> key, since viper cannot read keys
+// below a key that has a value of its own.
+func collectorKey(name, setting string) string {
+	return "collector-" + name + "-" + setting
+}
+
+// bindConfig makes the flags of fs available through v, overridden by
+// environment variables named after the flags in upper case with - and .
+// replaced by _.
+func bindConfig(v *viper.Viper, fs *pflag.FlagSet) error {
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_", ".", "_"))
+	v.AutomaticEnv()
+	return v.BindPFlags(fs)
+}
+
+// collectorConfigs returns the configuration of every enabled collector, with
+// unset settings taken from the defaults of all collectors.
+func collectorConfigs(v *viper.Viper) []metrics.CollectorConfig {
+	var collectors []metrics.CollectorConfig
+	for _, name := range metrics.Collectors() {
+		if !v.GetBool("collector."+name) || v.GetBool("no-collector."+name) {
+			continue
+		}
+		cfg := metrics.CollectorConfig{
+			Name:     name,
+			Mode:     metrics.Mode(v.GetString(collectorKey(name, "mode"))),
+			Interval: v.GetDuration(collectorKey(name, "interval")),
+			Timeout:  v.GetDuration(collectorKey(name, "timeout")),
+		}
+		if cfg.Mode == "" {
+			cfg.Mode = metrics.Mode(v.GetString("collect-mode"))
+		}
+		if cfg.Interval == 0 {
+			cfg.Interval = v.GetDuration("collect-interval")
+		}
+		if cfg.Timeout == 0 {
+			cfg.Timeout = v.GetDuration("collect-timeout")
+		}
+		if v.IsSet(collectorKey(name, "cache-ttl")) {
+			cfg.CacheTTL = v.GetDuration(collectorKey(name, "cache-ttl"))
+		} else {
+			cfg.CacheTTL = v.GetDuration("cache-ttl")
+		}
+		collectors = append(collectors, cfg)
+	}
+	return collectors
+}
+
+// collectorOptions returns the settings of individual collectors.
+func collectorOptions(v *viper.Viper) metrics.Options {
+	return metrics.Options{
+		JobOutcomesWindow:   v.GetDuration(collectorKey("job-outcomes", "window")),
+		CrawlExecutionsTopN: v.GetInt(collectorKey("crawl-executions", "top-n")),
+		StuckThreshold:      v.GetDuration(collectorKey("stuck-executions", "threshold")),
+		ProgressStallAfter:  v.GetDuration(collectorKey("job-progress", "stall-after")),
+	}
+}
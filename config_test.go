@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nlnwa/veidemann-metrics/internal/metrics"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// testConfig parses args and binds the flags and environment variables like
+// main does.
+func testConfig(t *testing.T, args ...string) *viper.Viper {
+	t.Helper()
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	registerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	v := viper.New()
+	if err := bindConfig(v, fs); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func configByName(configs []metrics.CollectorConfig) map[string]metrics.CollectorConfig {
+	byName := make(map[string]metrics.CollectorConfig)
+	for _, cfg := range configs {
+		byName[cfg.Name] = cfg
+	}
+	return byName
+}
+
+func TestCollectorConfigs(t *testing.T) {
+	t.Setenv("COLLECTOR_JOBS_INTERVAL", "1m")
+	t.Setenv("COLLECTOR_JOBS_MODE", "scrape")
+	t.Setenv("COLLECTOR_URI_QUEUE_CACHE_TTL", "0s")
+	t.Setenv("COLLECTOR_JOB_EXECUTIONS", "true")
+	v := testConfig(t, "--collector-uri-queue-timeout=3s", "--collect-interval=20s")
+
+	got := configByName(collectorConfigs(v))
+	want := map[string]metrics.CollectorConfig{
+		"jobs":           {Name: "jobs", Mode: metrics.ModeScrape, Interval: time.Minute, Timeout: 10 * time.Second, CacheTTL: 5 * time.Second},
+		"uri-queue":      {Name: "uri-queue", Mode: metrics.ModeBackground, Interval: 20 * time.Second, Timeout: 3 * time.Second},
+		"job-executions": {Name: "job-executions", Mode: metrics.ModeBackground, Interval: 20 * time.Second, Timeout: 10 * time.Second, CacheTTL: 5 * time.Second},
+	}
+	if len(got) != len(want) {
+		t.Errorf("enabled collectors = %v, want %v", got, want)
+	}
+	for name, cfg := range want {
+		if got[name] != cfg {
+			t.Errorf("config of %s = %+v, want %+v", name, got[name], cfg)
+		}
+	}
+}
+
+func TestCollectorConfigsDisabled(t *testing.T) {
+	t.Setenv("NO_COLLECTOR_JOBS", "true")
+	v := testConfig(t, "--no-collector.uri-queue")
+
+	if got := collectorConfigs(v); len(got) != 0 {
+		t.Errorf("expected no enabled collectors, got %v", got)
+	}
+}
+
+func TestJobsChangefeedConfig(t *testing.T) {
+	if testConfig(t).GetBool(collectorKey("jobs", "changefeed")) {
+		t.Error("expected changefeed to be disabled by default")
+	}
+	t.Setenv("COLLECTOR_JOBS_CHANGEFEED", "true")
+	if !testConfig(t).GetBool(collectorKey("jobs", "changefeed")) {
+		t.Error("expected changefeed to be enabled from the environment")
+	}
+}
+
+func TestCollectorOptions(t *testing.T) {
+	t.Setenv("COLLECTOR_JOB_OUTCOMES_WINDOW", "24h")
+	got := collectorOptions(testConfig(t))
+	if got.JobOutcomesWindow != 24*time.Hour {
+		t.Errorf("job outcomes window = %v, want 24h", got.JobOutcomesWindow)
+	}
+}
+
+func TestCollectorOptionsDefaults(t *testing.T) {
+	got := collectorOptions(testConfig(t))
+	if got.CrawlExecutionsTopN != 10 {
+		t.Errorf("crawl executions top-n = %d, want 10", got.CrawlExecutionsTopN)
+	}
+	if got.StuckThreshold != time.Hour {
+		t.Errorf("stuck threshold = %v, want 1h", got.StuckThreshold)
+	}
+	if got.ProgressStallAfter != time.Hour {
+		t.Errorf("progress stall after = %v, want 1h", got.ProgressStallAfter)
+	}
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright 2024 National Library of Norway.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package follow keeps changefeeds of a data source subscribed.
+package follow
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// Run calls fn until ctx is done, resubscribing when fn returns. It waits with
+// exponential backoff between attempts, starting over when an attempt lasted
+// longer than the longest backoff.
+func Run(ctx context.Context, source string, fn func(ctx context.Context) error) {
+	backoff := minBackoff
+	for {
+		start := time.Now()
+		err := fn(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > maxBackoff {
+			backoff = minBackoff
+		}
+		log.Warn().Err(err).Str("source", source).Dur("backoff", backoff).Msg("Stopped following, resubscribing")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
@@ -0,0 +1,20 @@
+package follow
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRunStopsWhenDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	Run(ctx, "crawl_log", func(ctx context.Context) error {
+		calls++
+		cancel()
+		return errors.New("changefeed closed")
+	})
+	if calls != 1 {
+		t.Errorf("expected one call, got %d", calls)
+	}
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright 2024 National Library of Norway.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+)
+
+var cacheAgeDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(Namespace, "exporter", "cache_age_seconds"),
+	"Age of the cached result of a collector.",
+	[]string{"collector"}, nil,
+)
+
+// errCacheClosed is returned by updates through a closed cache.
+var errCacheClosed = errors.New("cache is closed")
+
+// Cache shares the results of collector updates between concurrent and
+// closely following scrapes, so that several scrapers cost one query.
+type Cache struct {
+	group singleflight.Group
+	// ctx bounds the lifetime of shared calls.
+	ctx   context.Context
+	calls sync.WaitGroup
+
+	mu      sync.Mutex
+	closed  bool
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	metrics []prometheus.Metric
+	time    time.Time
+}
+
+// NewCache creates an empty Cache whose shared calls are canceled when ctx is
+// done.
+func NewCache(ctx context.Context) *Cache {
+	return &Cache{ctx: ctx, entries: make(map[string]cacheEntry)}
+}
+
+// Close makes later calls fail and waits for running shared calls to return,
+// so that the connections they use can be closed.
+func (c *Cache) Close() {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.calls.Wait()
+}
+
+// get returns the cached result for key if it is younger than ttl. Otherwise it
+// calls fn, sharing the call with concurrent callers of the same key, and
+// caches the result if successful. The returned time is when the result was
+// produced.
+//
+// A shared call is not canceled with the context of the caller that started
+// it, but with the context of the cache, and is bounded by timeout, so that
+// one caller giving up does not fail the others. Every caller stops waiting
+// when its own context is done.
+func (c *Cache) get(ctx context.Context, key string, ttl, timeout time.Duration, fn func(context.Context) ([]prometheus.Metric, error)) ([]prometheus.Metric, time.Time, error) {
+	if e, ok := c.entry(key); ok && time.Since(e.time) < ttl {
+		return e.metrics, e.time, nil
+	}
+
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return nil, errCacheClosed
+		}
+		c.calls.Add(1)
+		c.mu.Unlock()
+		defer c.calls.Done()
+
+		callCtx, cancel := context.WithTimeout(c.ctx, timeout)
+		defer cancel()
+		m, err := fn(callCtx)
+		if err != nil {
+			return nil, err
+		}
+		e := cacheEntry{metrics: m, time: time.Now()}
+		c.mu.Lock()
+		c.entries[key] = e
+		c.mu.Unlock()
+		return e, nil
+	})
+	var res singleflight.Result
+	select {
+	case res = <-ch:
+	case <-ctx.Done():
+		return nil, time.Time{}, ctx.Err()
+	}
+	if res.Err != nil {
+		return nil, time.Time{}, res.Err
+	}
+	e, _ := res.Val.(cacheEntry)
+	return e.metrics, e.time, nil
+}
+
+func (c *Cache) entry(key string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	return e, ok
+}
+
+// age returns how long ago the cached result for key was produced.
+func (c *Cache) age(key string) (time.Duration, bool) {
+	e, ok := c.entry(key)
+	if !ok {
+		return 0, false
+	}
+	return time.Since(e.time), true
+}
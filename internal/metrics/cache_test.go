@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCacheSharesConcurrentCalls(t *testing.T) {
+	c := NewCache(context.Background())
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fn := func(context.Context) ([]prometheus.Metric, error) {
+		calls.Add(1)
+		<-release
+		return nil, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := c.get(context.Background(), "jobs", 0, time.Minute, fn); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	// Let all callers join the call before it returns
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected one call, got %d", n)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	c := NewCache(context.Background())
+	var calls int
+	fn := func(context.Context) ([]prometheus.Metric, error) {
+		calls++
+		return nil, nil
+	}
+
+	_, first, _ := c.get(context.Background(), "jobs", time.Hour, time.Minute, fn)
+	_, second, _ := c.get(context.Background(), "jobs", time.Hour, time.Minute, fn)
+	if calls != 1 || !first.Equal(second) {
+		t.Errorf("expected cached result within ttl, got %d calls", calls)
+	}
+	if _, _, err := c.get(context.Background(), "jobs", 0, time.Minute, fn); err != nil || calls != 2 {
+		t.Errorf("expected new call with zero ttl, got %d calls", calls)
+	}
+	if _, ok := c.age("jobs"); !ok {
+		t.Error("expected age of cached entry")
+	}
+
+	failing := func(context.Context) ([]prometheus.Metric, error) {
+		return nil, errors.New("boom")
+	}
+	if _, _, err := c.get(context.Background(), "uri-queue", time.Hour, time.Minute, failing); err == nil {
+		t.Error("expected error")
+	}
+	if _, ok := c.age("uri-queue"); ok {
+		t.Error("expected failed result not to be cached")
+	}
+}
+
+func TestCacheCallerGivingUpDoesNotFailOthers(t *testing.T) {
+	c := NewCache(context.Background())
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fn := func(ctx context.Context) ([]prometheus.Metric, error) {
+		close(started)
+		select {
+		case <-release:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	first, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, _, err := c.get(first, "jobs", 0, time.Minute, fn)
+		firstErr <- err
+	}()
+	<-started
+
+	secondErr := make(chan error, 1)
+	go func() {
+		_, _, err := c.get(context.Background(), "jobs", 0, time.Minute, fn)
+		secondErr <- err
+	}()
+	// Let the second caller join the call before the first gives up
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the first caller to give up, got %v", err)
+	}
+
+	close(release)
+	if err := <-secondErr; err != nil {
+		t.Errorf("expected the second caller to get the shared result, got %v", err)
+	}
+}
+
+func TestCacheSharedCallTimeout(t *testing.T) {
+	c := NewCache(context.Background())
+	fn := func(ctx context.Context) ([]prometheus.Metric, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if _, _, err := c.get(context.Background(), "jobs", 0, 10*time.Millisecond, fn); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the shared call to time out, got %v", err)
+	}
+}
+
+func TestCacheClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := NewCache(ctx)
+	started := make(chan struct{})
+	var returned atomic.Bool
+	fn := func(ctx context.Context) ([]prometheus.Metric, error) {
+		close(started)
+		<-ctx.Done()
+		returned.Store(true)
+		return nil, ctx.Err()
+	}
+
+	// The caller gives up at once, leaving the shared call running
+	caller, giveUp := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		giveUp()
+	}()
+	if _, _, err := c.get(caller, "jobs", 0, time.Minute, fn); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the caller to give up, got %v", err)
+	}
+
+	cancel()
+	c.Close()
+	if !returned.Load() {
+		t.Error("expected Close to wait for the shared call")
+	}
+	if _, _, err := c.get(context.Background(), "jobs", 0, time.Minute, fn); !errors.Is(err, errCacheClosed) {
+		t.Errorf("expected calls after Close to fail, got %v", err)
+	}
+}
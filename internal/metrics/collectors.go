@@ -17,39 +17,167 @@
 package metrics
 
 import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	frontierV1 "github.com/nlnwa/veidemann-api/go/frontier/v1"
+	logV1 "github.com/nlnwa/veidemann-api/go/log/v1"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/collectors/version"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 const (
 	Namespace = "veidemann"
 )
 
+// JobExecutionSource provides job executions with the jobId replaced by the
+// name of the crawl job.
+type JobExecutionSource interface {
+	// WalkLatestJobExecutionForCrawlJobs calls fn with the latest job execution of every crawl job.
+	WalkLatestJobExecutionForCrawlJobs(ctx context.Context, fn func(*frontierV1.JobExecutionStatus)) error
+	// WalkRunningJobExecutions calls fn with every job execution that has not reached a terminal state.
+	WalkRunningJobExecutions(ctx context.Context, fn func(*frontierV1.JobExecutionStatus)) error
+}
+
+// JobHistorySource provides the history of job executions with the jobId
+// replaced by the name of the crawl job.
+type JobHistorySource interface {
+	// WalkLatestEndedJobExecutions calls fn with the job execution of every
+	// crawl job that ended last in each of the given states.
+	WalkLatestEndedJobExecutions(ctx context.Context, states []frontierV1.JobExecutionStatus_State, fn func(*frontierV1.JobExecutionStatus)) error
+	// CountJobExecutions calls fn with the number of job executions of every
+	// crawl job in each of the given states, counting only executions started
+	// at or after since unless it is zero.
+	CountJobExecutions(ctx context.Context, states []frontierV1.JobExecutionStatus_State, since time.Time, fn func(job string, state frontierV1.JobExecutionStatus_State, count int64)) error
+}
+
+// CrawlExecutionSource provides the crawl executions of job executions.
+type CrawlExecutionSource interface {
+	// WalkCrawlExecutions calls fn with every crawl execution of a job execution.
+	WalkCrawlExecutions(ctx context.Context, jobExecutionId string, fn func(*frontierV1.CrawlExecutionStatus)) error
+	// LastCrawlExecutionChange returns the latest time a crawl execution of a
+	// job execution changed, or the zero time if it has no crawl executions.
+	LastCrawlExecutionChange(ctx context.Context, jobExecutionId string) (time.Time, error)
+}
+
+// CrawlLogSource follows the crawl log.
+type CrawlLogSource interface {
+	// FollowCrawlLog calls fn with every new crawl log entry and the name of
+	// its crawl job, and dropped for every entry that is skipped, until ctx
+	// is done or following fails.
+	FollowCrawlLog(ctx context.Context, fn func(job string, entry *logV1.CrawlLog), dropped func()) error
+}
+
+// PageLogSource follows the page log.
+type PageLogSource interface {
+	// FollowPageLog calls fn with every new page log entry and the name of
+	// its crawl job, and dropped for every entry that is skipped, until ctx
+	// is done or following fails.
+	FollowPageLog(ctx context.Context, fn func(job string, entry *logV1.PageLog), dropped func()) error
+}
+
+// QueueSource provides the total number of queued uris.
+type QueueSource interface {
+	QueueCountTotal(ctx context.Context) (int64, error)
+}
+
+// Sources are the data sources available to collectors.
+type Sources struct {
+	JobExecutions   JobExecutionSource
+	JobHistory      JobHistorySource
+	CrawlExecutions CrawlExecutionSource
+	CrawlLog        CrawlLogSource
+	PageLog         PageLogSource
+	Frontier        QueueSource
+}
+
+// Options are the settings of individual collectors.
+type Options struct {
+	// JobOutcomesWindow is how far back the job-outcomes collector counts job
+	// executions by start time. Zero counts the whole history.
+	JobOutcomesWindow time.Duration
+	// CrawlExecutionsTopN is the number of crawl executions of each running
+	// job execution the crawl-executions collector exports by seed.
+	CrawlExecutionsTopN int
+	// StuckThreshold is how long a fetching or sleeping crawl execution may go
+	// without changing before the stuck-executions collector reports it.
+	StuckThreshold time.Duration
+	// ProgressStallAfter is how long a running job execution may go without
+	// progress before the job-progress collector reports it as stalled.
+	ProgressStallAfter time.Duration
+}
+
+// Collector gathers the metrics of a single data source.
+type Collector interface {
+	// Describe sends the descriptors of all metrics the collector can produce.
+	Describe(ch chan<- *prometheus.Desc)
+	// Update returns the current metrics of the data source.
+	Update(ctx context.Context) ([]prometheus.Metric, error)
+}
+
+// follower is implemented by collectors that follow a data source continuously
+// instead of querying it on every update. Follow is run in the background by
+// Exporter.Run and returns when ctx is done.
+type follower interface {
+	Follow(ctx context.Context)
+}
+
+type factory func(sources Sources, options Options) (Collector, error)
+
 var (
-	JobStatus = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Subsystem: "job",
-		Name:      "status_total",
-		Help:      "Status for running jobs",
-	}, []string{"job_name", "status"})
-
-	JobSize = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Subsystem: "job",
-		Name:      "size_total",
-		Help:      "Sizes for running jobs",
-	}, []string{"job_name", "type"})
+	factories      = make(map[string]factory)
+	defaultEnabled = make(map[string]bool)
+	following      = make(map[string]bool)
 )
 
-func registerCollectors(collectUriQueueLength func() float64) {
-	prometheus.MustRegister(version.NewCollector("veidemann_exporter"))
+// registerCollector makes a collector available by name. Collectors that are
+// expensive to run should not be enabled by default.
+func registerCollector(name string, isDefaultEnabled bool, f factory) {
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("collector %q is already registered", name))
+	}
+	factories[name] = f
+	defaultEnabled[name] = isDefaultEnabled
+}
+
+// registerFollowingCollector makes a collector implementing follower available
+// by name.
+func registerFollowingCollector(name string, isDefaultEnabled bool, f factory) {
+	registerCollector(name, isDefaultEnabled, f)
+	following[name] = true
+}
+
+// Collectors returns the names of all registered collectors in sorted order.
+func Collectors() []string {
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultEnabled reports whether the named collector is enabled by default.
+func DefaultEnabled(name string) bool {
+	return defaultEnabled[name]
+}
+
+// Follows reports whether the named collector follows its data source, so it
+// only has data when run by Exporter.Run.
+func Follows(name string) bool {
+	return following[name]
+}
 
-	prometheus.MustRegister(prometheus.NewGaugeFunc(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Subsystem: "uri",
-			Name:      "queue_count",
-			Help:      "Number of uris in queue.",
-		}, func() float64 { return collectUriQueueLength() }))
+// newCollector creates the named collector.
+func newCollector(name string, sources Sources, options Options) (Collector, error) {
+	f, ok := factories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown collector: %s", name)
+	}
+	c, err := f(sources, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create collector %s: %w", name, err)
+	}
+	return c, nil
 }
@@ -0,0 +1,277 @@
+/*
+ * Copyright 2024 National Library of Norway.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	frontierV1 "github.com/nlnwa/veidemann-api/go/frontier/v1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	crawlExecutionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "crawl", "executions"),
+		"Number of crawl executions of a running job execution by state.",
+		[]string{"job_name", "execution_id", "state"}, nil,
+	)
+
+	crawlExecutionStateDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "crawl_execution", "state"),
+		"State of a crawl execution of a seed, 1 for the current state and 0 for the others.",
+		[]string{"job_name", "execution_id", "seed_id", "state"}, nil,
+	)
+
+	crawlExecutionSizeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "crawl_execution", "size_total"),
+		"Sizes for crawl executions of a seed.",
+		[]string{"job_name", "execution_id", "seed_id", "type"}, nil,
+	)
+)
+
+var (
+	// crawlExecutionQueueWaitBuckets range from seconds to a week.
+	crawlExecutionQueueWaitBuckets = []float64{1, 10, 60, 300, 900, 3600, 3 * 3600, 6 * 3600, 12 * 3600, 86400, 2 * 86400, 7 * 86400}
+	// crawlExecutionDurationBuckets range from a minute to a month.
+	crawlExecutionDurationBuckets = []float64{60, 300, 900, 1800, 3600, 3 * 3600, 6 * 3600, 12 * 3600, 86400, 2 * 86400, 7 * 86400, 30 * 86400}
+)
+
+// crawlExecutionStates are the states of a crawl execution in enum order.
+var crawlExecutionStates = func() []frontierV1.CrawlExecutionStatus_State {
+	states := make([]frontierV1.CrawlExecutionStatus_State, 0, len(frontierV1.CrawlExecutionStatus_State_name))
+	for n := range frontierV1.CrawlExecutionStatus_State_name {
+		states = append(states, frontierV1.CrawlExecutionStatus_State(n))
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i] < states[j] })
+	return states
+}()
+
+func init() {
+	registerCollector("crawl-executions", false, newCrawlExecutionCollector)
+}
+
+// crawlExecutionCollector collects the crawl executions of every running job
+// execution from the executions table.
+type crawlExecutionCollector struct {
+	jobs   JobExecutionSource
+	source CrawlExecutionSource
+	// topN is the number of crawl executions with the most uris crawled that
+	// are exported by seed for each job execution.
+	topN int
+
+	queueWait *prometheus.HistogramVec
+	duration  *prometheus.HistogramVec
+
+	mu sync.Mutex
+	// observed maps the ids of running job executions to their ended crawl
+	// executions that have been observed in the histograms.
+	observed map[string]*observedJobExecution
+}
+
+// observedJobExecution is a job execution and the ids of its ended crawl
+// executions that have been observed in the histograms.
+type observedJobExecution struct {
+	job   string
+	ended map[string]struct{}
+}
+
+func newCrawlExecutionCollector(sources Sources, options Options) (Collector, error) {
+	if sources.JobExecutions == nil || sources.CrawlExecutions == nil {
+		return nil, errors.New("no database configured")
+	}
+	if options.CrawlExecutionsTopN < 0 {
+		return nil, errors.New("top-n must not be negative")
+	}
+	return &crawlExecutionCollector{
+		jobs:   sources.JobExecutions,
+		source: sources.CrawlExecutions,
+		topN:   options.CrawlExecutionsTopN,
+		queueWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "crawl_execution",
+			Name:      "queue_wait_seconds",
+			Help:      "Time ended crawl executions waited from being created until they started fetching.",
+			Buckets:   crawlExecutionQueueWaitBuckets,
+		}, []string{"job_name", "state"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "crawl_execution",
+			Name:      "duration_seconds",
+			Help:      "Time ended crawl executions took from being created until they ended.",
+			Buckets:   crawlExecutionDurationBuckets,
+		}, []string{"job_name", "state"}),
+		observed: make(map[string]*observedJobExecution),
+	}, nil
+}
+
+func (c *crawlExecutionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- crawlExecutionsDesc
+	ch <- crawlExecutionStateDesc
+	ch <- crawlExecutionSizeDesc
+	c.queueWait.Describe(ch)
+	c.duration.Describe(ch)
+}
+
+// Update returns the number of crawl executions in every state, and the state
+// and sizes of the crawl executions with the most uris crawled, of every
+// running job execution.
+//
+// Every crawl execution is observed once in the queue wait and duration
+// histograms, by the first update that finds it ended, so the histograms
+// accumulate over the lifetime of the exporter. The crawl executions of a job
+// execution that is no longer running are read once more, to observe those
+// that ended since the previous update, before the job execution is forgotten.
+func (c *crawlExecutionCollector) Update(ctx context.Context) ([]prometheus.Metric, error) {
+	var running []*frontierV1.JobExecutionStatus
+	err := c.jobs.WalkRunningJobExecutions(ctx, func(jes *frontierV1.JobExecutionStatus) {
+		running = append(running, jes)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query running job executions: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var metrics []prometheus.Metric
+	ids := make(map[string]struct{}, len(running))
+	for _, jes := range running {
+		ids[jes.GetId()] = struct{}{}
+		counts := make(map[frontierV1.CrawlExecutionStatus_State]int)
+		top := make([]*frontierV1.CrawlExecutionStatus, 0, c.topN+1)
+		o := c.observed[jes.GetId()]
+		if o == nil {
+			o = &observedJobExecution{job: jes.GetJobId(), ended: make(map[string]struct{})}
+			c.observed[jes.GetId()] = o
+		}
+		err := c.source.WalkCrawlExecutions(ctx, jes.GetId(), func(ces *frontierV1.CrawlExecutionStatus) {
+			counts[ces.GetState()]++
+			top = insertTopN(top, ces, c.topN)
+			c.observeOnce(o, ces)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query crawl executions of job execution %s: %w", jes.GetId(), err)
+		}
+
+		name, id := jes.GetJobId(), jes.GetId()
+		for _, state := range crawlExecutionStates {
+			metrics = append(metrics, prometheus.MustNewConstMetric(crawlExecutionsDesc, prometheus.GaugeValue, float64(counts[state]), name, id, state.String()))
+		}
+		for _, ces := range top {
+			metrics = append(metrics, crawlExecutionMetrics(ces, name, id, ces.GetSeedId())...)
+		}
+	}
+	// Observe the crawl executions that ended last in job executions that are
+	// no longer running, and forget them
+	for id, o := range c.observed {
+		if _, ok := ids[id]; ok {
+			continue
+		}
+		err := c.source.WalkCrawlExecutions(ctx, id, func(ces *frontierV1.CrawlExecutionStatus) {
+			c.observeOnce(o, ces)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query crawl executions of job execution %s: %w", id, err)
+		}
+		delete(c.observed, id)
+	}
+	return append(metrics, collectMetrics(c.queueWait, c.duration)...), nil
+}
+
+// observeOnce observes an ended crawl execution of a job execution unless it has already been observed.
+func (c *crawlExecutionCollector) observeOnce(o *observedJobExecution, ces *frontierV1.CrawlExecutionStatus) {
+	if !isCrawlExecutionEnded(ces) {
+		return
+	}
+	if _, ok := o.ended[ces.GetId()]; ok {
+		return
+	}
+	o.ended[ces.GetId()] = struct{}{}
+	c.observe(o.job, ces)
+}
+
+// observe observes the queue wait and duration of an ended crawl execution.
+func (c *crawlExecutionCollector) observe(job string, ces *frontierV1.CrawlExecutionStatus) {
+	if ces.GetCreatedTime() == nil {
+		return
+	}
+	state := ces.GetState().String()
+	created := ces.GetCreatedTime().AsTime()
+	if ces.GetStartTime() != nil {
+		c.queueWait.WithLabelValues(job, state).Observe(ces.GetStartTime().AsTime().Sub(created).Seconds())
+	}
+	if ces.GetEndTime() != nil {
+		c.duration.WithLabelValues(job, state).Observe(ces.GetEndTime().AsTime().Sub(created).Seconds())
+	}
+}
+
+// isCrawlExecutionEnded reports whether a crawl execution has reached a terminal state.
+func isCrawlExecutionEnded(ces *frontierV1.CrawlExecutionStatus) bool {
+	switch ces.GetState() {
+	case frontierV1.CrawlExecutionStatus_UNDEFINED,
+		frontierV1.CrawlExecutionStatus_CREATED,
+		frontierV1.CrawlExecutionStatus_FETCHING,
+		frontierV1.CrawlExecutionStatus_SLEEPING:
+		return false
+	default:
+		return true
+	}
+}
+
+// insertTopN inserts ces into top, which is sorted by uris crawled in
+// descending order, keeping at most n crawl executions.
+func insertTopN(top []*frontierV1.CrawlExecutionStatus, ces *frontierV1.CrawlExecutionStatus, n int) []*frontierV1.CrawlExecutionStatus {
+	i := sort.Search(len(top), func(i int) bool {
+		return top[i].GetUrisCrawled() < ces.GetUrisCrawled()
+	})
+	if i >= n {
+		return top
+	}
+	top = append(top, nil)
+	copy(top[i+1:], top[i:])
+	top[i] = ces
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// crawlExecutionMetrics returns the state and sizes of a crawl execution,
+// labelled with labelValues followed by the state or size type.
+func crawlExecutionMetrics(ces *frontierV1.CrawlExecutionStatus, labelValues ...string) []prometheus.Metric {
+	metrics := make([]prometheus.Metric, 0, len(crawlExecutionStates)+7)
+	for _, state := range crawlExecutionStates {
+		metrics = append(metrics, prometheus.MustNewConstMetric(crawlExecutionStateDesc, prometheus.GaugeValue,
+			boolToFloat64(ces.GetState() == state), append(labelValues, state.String())...))
+	}
+	size := func(typ string, value int64) prometheus.Metric {
+		return prometheus.MustNewConstMetric(crawlExecutionSizeDesc, prometheus.GaugeValue, float64(value), append(labelValues, typ)...)
+	}
+	return append(metrics,
+		size("documentsCrawled", ces.GetDocumentsCrawled()),
+		size("documentsDenied", ces.GetDocumentsDenied()),
+		size("documentsFailed", ces.GetDocumentsFailed()),
+		size("documentsOutOfScope", ces.GetDocumentsOutOfScope()),
+		size("documentsRetried", ces.GetDocumentsRetried()),
+		size("urisCrawled", ces.GetUrisCrawled()),
+		size("bytesCrawled", ces.GetBytesCrawled()),
+	)
+}
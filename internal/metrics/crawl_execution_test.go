@@ -0,0 +1,191 @@
+package metrics
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	frontierV1 "github.com/nlnwa/veidemann-api/go/frontier/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeCrawlExecutionSource is every crawl execution of every job execution.
+type fakeCrawlExecutionSource []*frontierV1.CrawlExecutionStatus
+
+func (f fakeCrawlExecutionSource) WalkCrawlExecutions(_ context.Context, jobExecutionId string, fn func(*frontierV1.CrawlExecutionStatus)) error {
+	for _, ces := range f {
+		if ces.GetJobExecutionId() == jobExecutionId {
+			fn(ces)
+		}
+	}
+	return nil
+}
+
+func (f fakeCrawlExecutionSource) LastCrawlExecutionChange(_ context.Context, jobExecutionId string) (time.Time, error) {
+	var lastChange time.Time
+	for _, ces := range f {
+		if ces.GetJobExecutionId() == jobExecutionId && ces.GetLastChangeTime().AsTime().After(lastChange) {
+			lastChange = ces.GetLastChangeTime().AsTime()
+		}
+	}
+	return lastChange, nil
+}
+
+func TestCrawlExecutionCollector(t *testing.T) {
+	sources := Sources{
+		JobExecutions: fakeJobExecutionSource{
+			{Id: "jes-1", JobId: "daily", State: frontierV1.JobExecutionStatus_RUNNING},
+			{Id: "jes-2", JobId: "daily", State: frontierV1.JobExecutionStatus_FINISHED},
+		},
+		CrawlExecutions: fakeCrawlExecutionSource{
+			{JobExecutionId: "jes-1", SeedId: "seed-1", State: frontierV1.CrawlExecutionStatus_FETCHING, UrisCrawled: 5},
+			{JobExecutionId: "jes-1", SeedId: "seed-2", State: frontierV1.CrawlExecutionStatus_DIED, UrisCrawled: 50},
+			{JobExecutionId: "jes-1", SeedId: "seed-3", State: frontierV1.CrawlExecutionStatus_UNDEFINED},
+			{JobExecutionId: "jes-1", SeedId: "seed-4", State: frontierV1.CrawlExecutionStatus_FINISHED, UrisCrawled: 20},
+			{JobExecutionId: "jes-2", SeedId: "seed-1", State: frontierV1.CrawlExecutionStatus_FINISHED, UrisCrawled: 100},
+		},
+	}
+	exp, err := New(sources, Options{CrawlExecutionsTopN: 2}, testConfigs("crawl-executions")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp.Update(context.Background())
+
+	expected := `
+# HELP veidemann_crawl_executions Number of crawl executions of a running job execution by state.
+# TYPE veidemann_crawl_executions gauge
+veidemann_crawl_executions{execution_id="jes-1",job_name="daily",state="ABORTED_MANUAL"} 0
+veidemann_crawl_executions{execution_id="jes-1",job_name="daily",state="ABORTED_SIZE"} 0
+veidemann_crawl_executions{execution_id="jes-1",job_name="daily",state="ABORTED_TIMEOUT"} 0
+veidemann_crawl_executions{execution_id="jes-1",job_name="daily",state="CREATED"} 0
+veidemann_crawl_executions{execution_id="jes-1",job_name="daily",state="DIED"} 1
+veidemann_crawl_executions{execution_id="jes-1",job_name="daily",state="FAILED"} 0
+veidemann_crawl_executions{execution_id="jes-1",job_name="daily",state="FETCHING"} 1
+veidemann_crawl_executions{execution_id="jes-1",job_name="daily",state="FINISHED"} 1
+veidemann_crawl_executions{execution_id="jes-1",job_name="daily",state="SLEEPING"} 0
+veidemann_crawl_executions{execution_id="jes-1",job_name="daily",state="UNDEFINED"} 1
+`
+	if err := testutil.CollectAndCompare(exp, strings.NewReader(expected), "veidemann_crawl_executions"); err != nil {
+		t.Error(err)
+	}
+
+	seeds := seriesByLabel(t, exp, "veidemann_crawl_execution_size_total", "seed_id")
+	if want := map[string]int{"seed-2": 7, "seed-4": 7}; !reflect.DeepEqual(seeds, want) {
+		t.Errorf("size series per seed = %v, want %v", seeds, want)
+	}
+}
+
+func TestInsertTopN(t *testing.T) {
+	var top []*frontierV1.CrawlExecutionStatus
+	for _, n := range []int64{3, 1, 4, 1, 5, 9, 2, 6} {
+		top = insertTopN(top, &frontierV1.CrawlExecutionStatus{UrisCrawled: n}, 3)
+	}
+	var got []int64
+	for _, ces := range top {
+		got = append(got, ces.GetUrisCrawled())
+	}
+	if len(got) != 3 || got[0] != 9 || got[1] != 6 || got[2] != 5 {
+		t.Errorf("top 3 = %v, want [9 6 5]", got)
+	}
+	if top := insertTopN(nil, &frontierV1.CrawlExecutionStatus{UrisCrawled: 1}, 0); len(top) != 0 {
+		t.Errorf("expected no crawl executions with n = 0, got %d", len(top))
+	}
+}
+
+func TestCrawlExecutionCollectorHistograms(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	crawlExecution := func(seed string, state frontierV1.CrawlExecutionStatus_State, wait, d time.Duration) *frontierV1.CrawlExecutionStatus {
+		ces := &frontierV1.CrawlExecutionStatus{
+			Id:             "ces-" + seed,
+			JobExecutionId: "jes-1",
+			SeedId:         seed,
+			State:          state,
+			CreatedTime:    timestamppb.New(created),
+		}
+		if wait > 0 {
+			ces.StartTime = timestamppb.New(created.Add(wait))
+		}
+		if d > 0 {
+			ces.EndTime = timestamppb.New(created.Add(wait + d))
+		}
+		return ces
+	}
+	jobs := fakeJobExecutionSource{
+		{Id: "jes-1", JobId: "daily", State: frontierV1.JobExecutionStatus_RUNNING},
+	}
+	last := crawlExecution("seed-2", frontierV1.CrawlExecutionStatus_FETCHING, 5*time.Second, 0)
+	sources := Sources{
+		JobExecutions: &jobs,
+		CrawlExecutions: fakeCrawlExecutionSource{
+			crawlExecution("seed-1", frontierV1.CrawlExecutionStatus_CREATED, 0, 0),
+			last,
+			crawlExecution("seed-3", frontierV1.CrawlExecutionStatus_FINISHED, 2*time.Minute, 10*time.Minute),
+			crawlExecution("seed-4", frontierV1.CrawlExecutionStatus_FINISHED, 30*time.Second, 2*time.Hour),
+			crawlExecution("seed-5", frontierV1.CrawlExecutionStatus_ABORTED_TIMEOUT, 30*time.Second, 25*time.Hour),
+		},
+	}
+	exp, err := New(sources, Options{}, testConfigs("crawl-executions")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Crawl executions are observed once however many updates find them, and
+	// are kept when their job execution ends
+	exp.Update(context.Background())
+	exp.Update(context.Background())
+	// The last crawl execution ends together with its job execution
+	last.State = frontierV1.CrawlExecutionStatus_FINISHED
+	last.EndTime = timestamppb.New(created.Add(time.Hour))
+	jobs[0].State = frontierV1.JobExecutionStatus_FINISHED
+	exp.Update(context.Background())
+
+	expected := `
+# HELP veidemann_crawl_execution_duration_seconds Time ended crawl executions took from being created until they ended.
+# TYPE veidemann_crawl_execution_duration_seconds histogram
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="ABORTED_TIMEOUT",le="60"} 0
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="ABORTED_TIMEOUT",le="300"} 0
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="ABORTED_TIMEOUT",le="900"} 0
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="ABORTED_TIMEOUT",le="1800"} 0
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="ABORTED_TIMEOUT",le="3600"} 0
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="ABORTED_TIMEOUT",le="10800"} 0
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="ABORTED_TIMEOUT",le="21600"} 0
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="ABORTED_TIMEOUT",le="43200"} 0
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="ABORTED_TIMEOUT",le="86400"} 0
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="ABORTED_TIMEOUT",le="172800"} 1
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="ABORTED_TIMEOUT",le="604800"} 1
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="ABORTED_TIMEOUT",le="2.592e+06"} 1
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="ABORTED_TIMEOUT",le="+Inf"} 1
+veidemann_crawl_execution_duration_seconds_sum{job_name="daily",state="ABORTED_TIMEOUT"} 90030
+veidemann_crawl_execution_duration_seconds_count{job_name="daily",state="ABORTED_TIMEOUT"} 1
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="FINISHED",le="60"} 0
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="FINISHED",le="300"} 0
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="FINISHED",le="900"} 1
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="FINISHED",le="1800"} 1
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="FINISHED",le="3600"} 2
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="FINISHED",le="10800"} 3
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="FINISHED",le="21600"} 3
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="FINISHED",le="43200"} 3
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="FINISHED",le="86400"} 3
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="FINISHED",le="172800"} 3
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="FINISHED",le="604800"} 3
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="FINISHED",le="2.592e+06"} 3
+veidemann_crawl_execution_duration_seconds_bucket{job_name="daily",state="FINISHED",le="+Inf"} 3
+veidemann_crawl_execution_duration_seconds_sum{job_name="daily",state="FINISHED"} 11550
+veidemann_crawl_execution_duration_seconds_count{job_name="daily",state="FINISHED"} 3
+`
+	if err := testutil.CollectAndCompare(exp, strings.NewReader(expected), "veidemann_crawl_execution_duration_seconds"); err != nil {
+		t.Error(err)
+	}
+
+	states := seriesByLabel(t, exp, "veidemann_crawl_execution_queue_wait_seconds", "state")
+	if want := map[string]int{"FINISHED": 1, "ABORTED_TIMEOUT": 1}; !reflect.DeepEqual(states, want) {
+		t.Errorf("queue wait series per state = %v, want %v", states, want)
+	}
+
+	c, _ := exp.collectors[0].Collector.(*crawlExecutionCollector)
+	if len(c.observed) != 0 {
+		t.Errorf("expected crawl executions of ended job execution to be forgotten, got %v", c.observed)
+	}
+}
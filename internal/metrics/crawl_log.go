@@ -0,0 +1,310 @@
+/*
+ * Copyright 2024 National Library of Norway.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	frontierV1 "github.com/nlnwa/veidemann-api/go/frontier/v1"
+	logV1 "github.com/nlnwa/veidemann-api/go/log/v1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerFollowingCollector("crawl-log", false, newCrawlLogCollector)
+}
+
+// statusCodes are the HTTP status codes exported by their own value. Other
+// codes are exported as "other" within their status class.
+var statusCodes = map[int32]bool{
+	200: true, 201: true, 204: true, 206: true,
+	301: true, 302: true, 303: true, 304: true, 307: true, 308: true,
+	400: true, 401: true, 403: true, 404: true, 405: true, 408: true, 410: true, 429: true,
+	500: true, 502: true, 503: true, 504: true,
+}
+
+var (
+	crawlLogRevisitRatioDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "crawl_log", "revisit_ratio"),
+		"Fraction of response and revisit records of a running job execution that are revisits, since the exporter started.",
+		[]string{"job_name", "execution_id"}, nil,
+	)
+
+	crawlLogDedupBytesRatioDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "crawl_log", "dedup_bytes_ratio"),
+		"Fraction of the bytes fetched by a running job execution that were not stored because of deduplication, since the exporter started.",
+		[]string{"job_name", "execution_id"}, nil,
+	)
+
+	crawlLogResubscriptionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "crawl_log", "resubscriptions_total"),
+		"Number of times following the crawl log was resumed after stopping. Entries written in between are not counted.",
+		nil, nil,
+	)
+)
+
+// Native histograms have buckets growing by this factor, and are reset to
+// a single bucket when they have more buckets than the limit.
+const (
+	nativeHistogramBucketFactor    = 1.1
+	nativeHistogramMaxBucketNumber = 160
+)
+
+// WARC record types of crawl log entries.
+const (
+	recordTypeResponse = "response"
+	recordTypeRevisit  = "revisit"
+)
+
+// crawlLogCollector counts the entries written to the crawl log since the
+// exporter started, following the crawl log by changefeed.
+type crawlLogCollector struct {
+	jobs   JobExecutionSource
+	source CrawlLogSource
+	state  followState
+
+	fetches    *prometheus.CounterVec
+	errors     *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+	size       *prometheus.HistogramVec
+	revisits   *prometheus.CounterVec
+	bytesSaved *prometheus.CounterVec
+	dropped    prometheus.Counter
+
+	mu sync.Mutex
+	// dedup maps job execution ids to their response and revisit records.
+	dedup map[string]*dedupCount
+}
+
+// dedupCount is the number and size of the response and revisit records of a
+// job execution.
+type dedupCount struct {
+	responses, revisits         int64
+	responseBytes, revisitBytes int64
+}
+
+func newCrawlLogCollector(sources Sources, _ Options) (Collector, error) {
+	if sources.JobExecutions == nil || sources.CrawlLog == nil {
+		return nil, errors.New("no database configured")
+	}
+	return &crawlLogCollector{
+		jobs:   sources.JobExecutions,
+		source: sources.CrawlLog,
+		dedup:  make(map[string]*dedupCount),
+		fetches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "crawl_log",
+			Name:      "fetches_total",
+			Help:      "Number of crawl log entries by status code. Negative status codes are Veidemann errors.",
+		}, []string{"job_name", "status_class", "status_code"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "fetch_errors_total",
+			Help:      "Number of failed fetches by the error given by a negative Veidemann status code.",
+		}, []string{"job_name", "error"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:                      Namespace,
+			Name:                           "fetch_duration_seconds",
+			Help:                           "Time spent fetching resources that got a response.",
+			Buckets:                        []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+			NativeHistogramBucketFactor:    nativeHistogramBucketFactor,
+			NativeHistogramMaxBucketNumber: nativeHistogramMaxBucketNumber,
+		}, []string{"job_name", "content_type"}),
+		size: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:                      Namespace,
+			Name:                           "fetch_size_bytes",
+			Help:                           "Size of fetched resources that got a response.",
+			Buckets:                        prometheus.ExponentialBuckets(1024, 4, 11),
+			NativeHistogramBucketFactor:    nativeHistogramBucketFactor,
+			NativeHistogramMaxBucketNumber: nativeHistogramMaxBucketNumber,
+		}, []string{"job_name", "content_type"}),
+		revisits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "crawl_log",
+			Name:      "revisits_total",
+			Help:      "Number of revisit records written instead of storing a duplicate response.",
+		}, []string{"job_name"}),
+		bytesSaved: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "crawl_log",
+			Name:      "dedup_saved_bytes_total",
+			Help:      "Number of fetched bytes not stored because of deduplication.",
+		}, []string{"job_name"}),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "crawl_log",
+			Name:      "dropped_entries_total",
+			Help:      "Number of crawl log entries that were not counted because they could not be decoded or their job could not be looked up.",
+		}),
+	}, nil
+}
+
+func (c *crawlLogCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.fetches.Describe(ch)
+	c.errors.Describe(ch)
+	c.duration.Describe(ch)
+	c.size.Describe(ch)
+	c.revisits.Describe(ch)
+	c.bytesSaved.Describe(ch)
+	c.dropped.Describe(ch)
+	ch <- crawlLogRevisitRatioDesc
+	ch <- crawlLogDedupBytesRatioDesc
+	ch <- crawlLogResubscriptionsDesc
+}
+
+// Follow counts crawl log entries until ctx is done.
+func (c *crawlLogCollector) Follow(ctx context.Context) {
+	c.state.follow(ctx, "crawl_log", func(ctx context.Context) error {
+		return c.source.FollowCrawlLog(ctx, c.observe, c.dropped.Inc)
+	})
+}
+
+func (c *crawlLogCollector) observe(job string, entry *logV1.CrawlLog) {
+	class, code := statusLabels(entry.GetStatusCode())
+	c.fetches.WithLabelValues(job, class, code).Inc()
+	if entry.GetStatusCode() < 0 {
+		c.errors.WithLabelValues(job, fetchErrorName(entry.GetStatusCode())).Inc()
+	}
+	if entry.GetStatusCode() > 0 {
+		family := contentTypeFamily(entry.GetContentType())
+		c.duration.WithLabelValues(job, family).Observe(float64(entry.GetFetchTimeMs()) / 1000)
+		c.size.WithLabelValues(job, family).Observe(float64(entry.GetSize()))
+	}
+	c.observeRecordType(job, entry)
+}
+
+// observeRecordType counts response and revisit records.
+func (c *crawlLogCollector) observeRecordType(job string, entry *logV1.CrawlLog) {
+	recordType := entry.GetRecordType()
+	if recordType != recordTypeResponse && recordType != recordTypeRevisit {
+		return
+	}
+	if recordType == recordTypeRevisit {
+		c.revisits.WithLabelValues(job).Inc()
+		c.bytesSaved.WithLabelValues(job).Add(float64(entry.GetSize()))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	count, ok := c.dedup[entry.GetJobExecutionId()]
+	if !ok {
+		count = new(dedupCount)
+		c.dedup[entry.GetJobExecutionId()] = count
+	}
+	if recordType == recordTypeRevisit {
+		count.revisits++
+		count.revisitBytes += entry.GetSize()
+	} else {
+		count.responses++
+		count.responseBytes += entry.GetSize()
+	}
+}
+
+// Update returns the current counts and the deduplication ratios of every
+// running job execution, or an error if the crawl log is not being followed.
+//
+// Counts of job executions that are no longer running are forgotten.
+func (c *crawlLogCollector) Update(ctx context.Context) ([]prometheus.Metric, error) {
+	if err := c.state.check(); err != nil {
+		return nil, fmt.Errorf("failed to follow crawl log: %w", err)
+	}
+
+	// Only counts that existed before listing the running job executions are
+	// forgotten, so a job execution started meanwhile keeps its counts.
+	c.mu.Lock()
+	stale := make(map[string]bool, len(c.dedup))
+	for id := range c.dedup {
+		stale[id] = true
+	}
+	c.mu.Unlock()
+
+	var running []*frontierV1.JobExecutionStatus
+	err := c.jobs.WalkRunningJobExecutions(ctx, func(jes *frontierV1.JobExecutionStatus) {
+		running = append(running, jes)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query running job executions: %w", err)
+	}
+
+	metrics := collectMetrics(c.fetches, c.errors, c.duration, c.size, c.revisits, c.bytesSaved, c.dropped)
+	metrics = append(metrics, prometheus.MustNewConstMetric(crawlLogResubscriptionsDesc, prometheus.CounterValue,
+		float64(c.state.resubscribed())))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, jes := range running {
+		delete(stale, jes.GetId())
+		count, ok := c.dedup[jes.GetId()]
+		if !ok {
+			continue
+		}
+		if records := count.responses + count.revisits; records > 0 {
+			metrics = append(metrics, prometheus.MustNewConstMetric(crawlLogRevisitRatioDesc, prometheus.GaugeValue,
+				float64(count.revisits)/float64(records), jes.GetJobId(), jes.GetId()))
+		}
+		if bytes := count.responseBytes + count.revisitBytes; bytes > 0 {
+			metrics = append(metrics, prometheus.MustNewConstMetric(crawlLogDedupBytesRatioDesc, prometheus.GaugeValue,
+				float64(count.revisitBytes)/float64(bytes), jes.GetJobId(), jes.GetId()))
+		}
+	}
+	for id := range stale {
+		delete(c.dedup, id)
+	}
+	return metrics, nil
+}
+
+// contentTypeFamily returns the family of a content type: html, image, video
+// or other.
+func contentTypeFamily(contentType string) string {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		return "html"
+	case strings.HasPrefix(mediaType, "image/"):
+		return "image"
+	case strings.HasPrefix(mediaType, "video/"):
+		return "video"
+	default:
+		return "other"
+	}
+}
+
+// statusLabels returns the status class and bucketed status code of a crawl
+// log status code.
+func statusLabels(statusCode int32) (class, code string) {
+	switch {
+	case statusCode < 0:
+		if _, ok := fetchErrors[statusCode]; ok {
+			return "error", strconv.Itoa(int(statusCode))
+		}
+		return "error", "other"
+	case statusCode >= 100 && statusCode < 600:
+		class = strconv.Itoa(int(statusCode)/100) + "xx"
+		if statusCodes[statusCode] {
+			return class, strconv.Itoa(int(statusCode))
+		}
+		return class, "other"
+	default:
+		return "other", "other"
+	}
+}
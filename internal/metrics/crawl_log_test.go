@@ -0,0 +1,299 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	frontierV1 "github.com/nlnwa/veidemann-api/go/frontier/v1"
+	logV1 "github.com/nlnwa/veidemann-api/go/log/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeCrawlLogSource sends its entries to the first follower and then blocks
+// until the follower is done.
+type fakeCrawlLogSource struct {
+	entries []fakeCrawlLogEntry
+	sent    chan struct{}
+}
+
+type fakeCrawlLogEntry struct {
+	job   string
+	entry *logV1.CrawlLog
+}
+
+func newFakeCrawlLogSource(entries ...fakeCrawlLogEntry) *fakeCrawlLogSource {
+	return &fakeCrawlLogSource{entries: entries, sent: make(chan struct{})}
+}
+
+func (f *fakeCrawlLogSource) FollowCrawlLog(ctx context.Context, fn func(string, *logV1.CrawlLog), dropped func()) error {
+	for _, e := range f.entries {
+		if e.entry == nil {
+			dropped()
+			continue
+		}
+		fn(e.job, e.entry)
+	}
+	close(f.sent)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// followTestExporter creates an exporter running the named collector and
+// returns when sent is closed by the source.
+func followTestExporter(t *testing.T, sources Sources, sent <-chan struct{}, name string) *Exporter {
+	t.Helper()
+	exp := newTestExporter(t, sources, name)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		exp.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	<-sent
+	exp.Update(context.Background())
+	return exp
+}
+
+func TestCrawlLogCollector(t *testing.T) {
+	source := newFakeCrawlLogSource(
+		fakeCrawlLogEntry{"daily", &logV1.CrawlLog{StatusCode: 200}},
+		fakeCrawlLogEntry{"daily", &logV1.CrawlLog{StatusCode: 200}},
+		fakeCrawlLogEntry{"daily", &logV1.CrawlLog{StatusCode: 429}},
+		fakeCrawlLogEntry{"daily", &logV1.CrawlLog{StatusCode: 418}},
+		fakeCrawlLogEntry{"daily", &logV1.CrawlLog{StatusCode: -2}},
+		fakeCrawlLogEntry{"daily", &logV1.CrawlLog{StatusCode: -123}},
+		fakeCrawlLogEntry{"weekly", &logV1.CrawlLog{StatusCode: 0}},
+	)
+	exp := followTestExporter(t, Sources{JobExecutions: fakeJobExecutionSource{}, CrawlLog: source}, source.sent, "crawl-log")
+
+	expected := `
+# HELP veidemann_crawl_log_fetches_total Number of crawl log entries by status code. Negative status codes are Veidemann errors.
+# TYPE veidemann_crawl_log_fetches_total counter
+veidemann_crawl_log_fetches_total{job_name="daily",status_class="2xx",status_code="200"} 2
+veidemann_crawl_log_fetches_total{job_name="daily",status_class="4xx",status_code="429"} 1
+veidemann_crawl_log_fetches_total{job_name="daily",status_class="4xx",status_code="other"} 1
+veidemann_crawl_log_fetches_total{job_name="daily",status_class="error",status_code="-2"} 1
+veidemann_crawl_log_fetches_total{job_name="daily",status_class="error",status_code="other"} 1
+veidemann_crawl_log_fetches_total{job_name="weekly",status_class="other",status_code="other"} 1
+`
+	if err := testutil.CollectAndCompare(exp, strings.NewReader(expected), "veidemann_crawl_log_fetches_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+// resubscribingCrawlLogSource fails the first follower, and sends its entries
+// to the next follower and then blocks until the follower is done.
+type resubscribingCrawlLogSource struct {
+	*fakeCrawlLogSource
+	failed bool
+}
+
+func (f *resubscribingCrawlLogSource) FollowCrawlLog(ctx context.Context, fn func(string, *logV1.CrawlLog), dropped func()) error {
+	if !f.failed {
+		f.failed = true
+		return errors.New("changefeed closed")
+	}
+	return f.fakeCrawlLogSource.FollowCrawlLog(ctx, fn, dropped)
+}
+
+func TestCrawlLogCollectorDropped(t *testing.T) {
+	source := &resubscribingCrawlLogSource{fakeCrawlLogSource: newFakeCrawlLogSource(
+		fakeCrawlLogEntry{"daily", &logV1.CrawlLog{StatusCode: 200}},
+		fakeCrawlLogEntry{"", nil},
+		fakeCrawlLogEntry{"", nil},
+	)}
+	exp := followTestExporter(t, Sources{JobExecutions: fakeJobExecutionSource{}, CrawlLog: source}, source.sent, "crawl-log")
+
+	expected := `
+# HELP veidemann_crawl_log_dropped_entries_total Number of crawl log entries that were not counted because they could not be decoded or their job could not be looked up.
+# TYPE veidemann_crawl_log_dropped_entries_total counter
+veidemann_crawl_log_dropped_entries_total 2
+# HELP veidemann_crawl_log_resubscriptions_total Number of times following the crawl log was resumed after stopping. Entries written in between are not counted.
+# TYPE veidemann_crawl_log_resubscriptions_total counter
+veidemann_crawl_log_resubscriptions_total 1
+`
+	if err := testutil.CollectAndCompare(exp, strings.NewReader(expected),
+		"veidemann_crawl_log_dropped_entries_total", "veidemann_crawl_log_resubscriptions_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCrawlLogCollectorFetchErrors(t *testing.T) {
+	source := newFakeCrawlLogSource(
+		fakeCrawlLogEntry{"daily", &logV1.CrawlLog{StatusCode: -1}},
+		fakeCrawlLogEntry{"daily", &logV1.CrawlLog{StatusCode: -1}},
+		fakeCrawlLogEntry{"daily", &logV1.CrawlLog{StatusCode: -4}},
+		fakeCrawlLogEntry{"daily", &logV1.CrawlLog{StatusCode: -123}},
+		fakeCrawlLogEntry{"daily", &logV1.CrawlLog{StatusCode: 500}},
+		fakeCrawlLogEntry{"weekly", &logV1.CrawlLog{StatusCode: -9998}},
+	)
+	exp := followTestExporter(t, Sources{JobExecutions: fakeJobExecutionSource{}, CrawlLog: source}, source.sent, "crawl-log")
+
+	expected := `
+# HELP veidemann_fetch_errors_total Number of failed fetches by the error given by a negative Veidemann status code.
+# TYPE veidemann_fetch_errors_total counter
+veidemann_fetch_errors_total{error="dns_lookup_failed",job_name="daily"} 2
+veidemann_fetch_errors_total{error="http_timeout",job_name="daily"} 1
+veidemann_fetch_errors_total{error="unknown",job_name="daily"} 1
+veidemann_fetch_errors_total{error="precluded_by_robots",job_name="weekly"} 1
+`
+	if err := testutil.CollectAndCompare(exp, strings.NewReader(expected), "veidemann_fetch_errors_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCrawlLogCollectorDedup(t *testing.T) {
+	response := func(id string, size int64) *logV1.CrawlLog {
+		return &logV1.CrawlLog{JobExecutionId: id, StatusCode: 200, RecordType: "response", Size: size}
+	}
+	revisit := func(id string, size int64) *logV1.CrawlLog {
+		return &logV1.CrawlLog{JobExecutionId: id, StatusCode: 200, RecordType: "revisit", Size: size}
+	}
+	source := newFakeCrawlLogSource(
+		fakeCrawlLogEntry{"daily", response("jes-1", 100)},
+		fakeCrawlLogEntry{"daily", response("jes-1", 100)},
+		fakeCrawlLogEntry{"daily", response("jes-1", 100)},
+		fakeCrawlLogEntry{"daily", revisit("jes-1", 300)},
+		fakeCrawlLogEntry{"daily", &logV1.CrawlLog{JobExecutionId: "jes-1", StatusCode: 1, RecordType: "resource", Size: 50}},
+		fakeCrawlLogEntry{"weekly", revisit("jes-2", 1000)},
+	)
+	jobs := fakeJobExecutionSource{
+		{Id: "jes-1", JobId: "daily", State: frontierV1.JobExecutionStatus_RUNNING},
+		{Id: "jes-2", JobId: "weekly", State: frontierV1.JobExecutionStatus_FINISHED},
+	}
+	exp := followTestExporter(t, Sources{JobExecutions: jobs, CrawlLog: source}, source.sent, "crawl-log")
+
+	expected := `
+# HELP veidemann_crawl_log_dedup_bytes_ratio Fraction of the bytes fetched by a running job execution that were not stored because of deduplication, since the exporter started.
+# TYPE veidemann_crawl_log_dedup_bytes_ratio gauge
+veidemann_crawl_log_dedup_bytes_ratio{execution_id="jes-1",job_name="daily"} 0.5
+# HELP veidemann_crawl_log_dedup_saved_bytes_total Number of fetched bytes not stored because of deduplication.
+# TYPE veidemann_crawl_log_dedup_saved_bytes_total counter
+veidemann_crawl_log_dedup_saved_bytes_total{job_name="daily"} 300
+veidemann_crawl_log_dedup_saved_bytes_total{job_name="weekly"} 1000
+# HELP veidemann_crawl_log_revisit_ratio Fraction of response and revisit records of a running job execution that are revisits, since the exporter started.
+# TYPE veidemann_crawl_log_revisit_ratio gauge
+veidemann_crawl_log_revisit_ratio{execution_id="jes-1",job_name="daily"} 0.25
+# HELP veidemann_crawl_log_revisits_total Number of revisit records written instead of storing a duplicate response.
+# TYPE veidemann_crawl_log_revisits_total counter
+veidemann_crawl_log_revisits_total{job_name="daily"} 1
+veidemann_crawl_log_revisits_total{job_name="weekly"} 1
+`
+	if err := testutil.CollectAndCompare(exp, strings.NewReader(expected),
+		"veidemann_crawl_log_dedup_bytes_ratio",
+		"veidemann_crawl_log_dedup_saved_bytes_total",
+		"veidemann_crawl_log_revisit_ratio",
+		"veidemann_crawl_log_revisits_total",
+	); err != nil {
+		t.Error(err)
+	}
+
+	c, _ := exp.collectors[0].Collector.(*crawlLogCollector)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.dedup["jes-2"]; ok {
+		t.Error("expected counts of ended job execution to be forgotten")
+	}
+}
+
+func TestCrawlLogCollectorNotFollowing(t *testing.T) {
+	exp := newTestExporter(t, Sources{JobExecutions: fakeJobExecutionSource{}, CrawlLog: newFakeCrawlLogSource()}, "crawl-log")
+	exp.Update(context.Background())
+
+	if v := testutil.ToFloat64(exp.errors.WithLabelValues("crawl-log", errorClassOther)); v != 1 {
+		t.Errorf("expected an error when the crawl log is not followed, got %v", v)
+	}
+
+	var s followState
+	s.set(false, context.DeadlineExceeded)
+	if err := s.check(); !errors.Is(err, errNotFollowing) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the cause of not following, got %v", err)
+	}
+}
+
+func TestCrawlLogCollectorFetchHistograms(t *testing.T) {
+	source := newFakeCrawlLogSource(
+		fakeCrawlLogEntry{"daily", &logV1.CrawlLog{StatusCode: 200, ContentType: "text/html; charset=utf-8", FetchTimeMs: 300, Size: 2000}},
+		fakeCrawlLogEntry{"daily", &logV1.CrawlLog{StatusCode: 200, ContentType: "text/html", FetchTimeMs: 700, Size: 50000}},
+		fakeCrawlLogEntry{"daily", &logV1.CrawlLog{StatusCode: 200, ContentType: "image/png", FetchTimeMs: 20, Size: 100}},
+		fakeCrawlLogEntry{"daily", &logV1.CrawlLog{StatusCode: -4, FetchTimeMs: 30000}},
+	)
+	exp := followTestExporter(t, Sources{JobExecutions: fakeJobExecutionSource{}, CrawlLog: source}, source.sent, "crawl-log")
+
+	expected := `
+# HELP veidemann_fetch_duration_seconds Time spent fetching resources that got a response.
+# TYPE veidemann_fetch_duration_seconds histogram
+veidemann_fetch_duration_seconds_bucket{content_type="html",job_name="daily",le="0.05"} 0
+veidemann_fetch_duration_seconds_bucket{content_type="html",job_name="daily",le="0.1"} 0
+veidemann_fetch_duration_seconds_bucket{content_type="html",job_name="daily",le="0.25"} 0
+veidemann_fetch_duration_seconds_bucket{content_type="html",job_name="daily",le="0.5"} 1
+veidemann_fetch_duration_seconds_bucket{content_type="html",job_name="daily",le="1"} 2
+veidemann_fetch_duration_seconds_bucket{content_type="html",job_name="daily",le="2.5"} 2
+veidemann_fetch_duration_seconds_bucket{content_type="html",job_name="daily",le="5"} 2
+veidemann_fetch_duration_seconds_bucket{content_type="html",job_name="daily",le="10"} 2
+veidemann_fetch_duration_seconds_bucket{content_type="html",job_name="daily",le="30"} 2
+veidemann_fetch_duration_seconds_bucket{content_type="html",job_name="daily",le="60"} 2
+veidemann_fetch_duration_seconds_bucket{content_type="html",job_name="daily",le="+Inf"} 2
+veidemann_fetch_duration_seconds_sum{content_type="html",job_name="daily"} 1
+veidemann_fetch_duration_seconds_count{content_type="html",job_name="daily"} 2
+veidemann_fetch_duration_seconds_bucket{content_type="image",job_name="daily",le="0.05"} 1
+veidemann_fetch_duration_seconds_bucket{content_type="image",job_name="daily",le="0.1"} 1
+veidemann_fetch_duration_seconds_bucket{content_type="image",job_name="daily",le="0.25"} 1
+veidemann_fetch_duration_seconds_bucket{content_type="image",job_name="daily",le="0.5"} 1
+veidemann_fetch_duration_seconds_bucket{content_type="image",job_name="daily",le="1"} 1
+veidemann_fetch_duration_seconds_bucket{content_type="image",job_name="daily",le="2.5"} 1
+veidemann_fetch_duration_seconds_bucket{content_type="image",job_name="daily",le="5"} 1
+veidemann_fetch_duration_seconds_bucket{content_type="image",job_name="daily",le="10"} 1
+veidemann_fetch_duration_seconds_bucket{content_type="image",job_name="daily",le="30"} 1
+veidemann_fetch_duration_seconds_bucket{content_type="image",job_name="daily",le="60"} 1
+veidemann_fetch_duration_seconds_bucket{content_type="image",job_name="daily",le="+Inf"} 1
+veidemann_fetch_duration_seconds_sum{content_type="image",job_name="daily"} 0.02
+veidemann_fetch_duration_seconds_count{content_type="image",job_name="daily"} 1
+`
+	if err := testutil.CollectAndCompare(exp, strings.NewReader(expected), "veidemann_fetch_duration_seconds"); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(exp, "veidemann_fetch_size_bytes"); n != 2 {
+		t.Errorf("expected size histograms of html and image, got %d", n)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(exp)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range families {
+		if mf.GetName() != "veidemann_fetch_size_bytes" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			if m.GetHistogram().Schema == nil {
+				t.Errorf("expected native histogram buckets for %v", m.GetLabel())
+			}
+		}
+	}
+}
+
+func TestContentTypeFamily(t *testing.T) {
+	tests := map[string]string{
+		"text/html; charset=UTF-8": "html",
+		"application/xhtml+xml":    "html",
+		"IMAGE/JPEG":               "image",
+		"video/mp4":                "video",
+		"text/css":                 "other",
+		"":                         "other",
+	}
+	for contentType, want := range tests {
+		if got := contentTypeFamily(contentType); got != want {
+			t.Errorf("contentTypeFamily(%q) = %q, want %q", contentType, got, want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright 2024 National Library of Norway.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+// Error classes used to label collector errors.
+const (
+	errorClassTimeout     = "timeout"
+	errorClassCanceled    = "canceled"
+	errorClassUnavailable = "unavailable"
+	errorClassQuery       = "query"
+	errorClassOther       = "other"
+)
+
+var errorClasses = []string{
+	errorClassTimeout,
+	errorClassCanceled,
+	errorClassUnavailable,
+	errorClassQuery,
+	errorClassOther,
+}
+
+// errorClass classifies errors returned by RethinkDB and the frontier.
+func errorClass(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, r.ErrQueryTimeout):
+		return errorClassTimeout
+	case errors.Is(err, context.Canceled):
+		return errorClassCanceled
+	case errors.Is(err, r.ErrConnectionClosed), errors.Is(err, r.ErrNoConnections), errors.Is(err, r.ErrNoHosts):
+		return errorClassUnavailable
+	}
+
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.DeadlineExceeded:
+			return errorClassTimeout
+		case codes.Canceled:
+			return errorClassCanceled
+		case codes.Unavailable:
+			return errorClassUnavailable
+		default:
+			return errorClassOther
+		}
+	}
+
+	var (
+		connectionErr      r.RQLConnectionError
+		timeoutErr         r.RQLTimeoutError
+		availabilityErr    r.RQLAvailabilityError
+		opFailedErr        r.RQLOpFailedError
+		opIndeterminateErr r.RQLOpIndeterminateError
+		runtimeErr         r.RQLRuntimeError
+		queryLogicErr      r.RQLQueryLogicError
+		nonExistenceErr    r.RQLNonExistenceError
+		compileErr         r.RQLCompileError
+		clientErr          r.RQLClientError
+	)
+	switch {
+	case errors.As(err, &timeoutErr):
+		return errorClassTimeout
+	case errors.As(err, &connectionErr),
+		errors.As(err, &availabilityErr),
+		errors.As(err, &opFailedErr),
+		errors.As(err, &opIndeterminateErr):
+		return errorClassUnavailable
+	case errors.As(err, &runtimeErr),
+		errors.As(err, &queryLogicErr),
+		errors.As(err, &nonExistenceErr),
+		errors.As(err, &compileErr),
+		errors.As(err, &clientErr):
+		return errorClassQuery
+	}
+	return errorClassOther
+}
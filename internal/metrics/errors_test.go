@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"deadline", fmt.Errorf("query: %w", context.DeadlineExceeded), errorClassTimeout},
+		{"canceled", context.Canceled, errorClassCanceled},
+		{"rethinkdb query timeout", r.ErrQueryTimeout, errorClassTimeout},
+		{"rethinkdb connection closed", fmt.Errorf("query: %w", r.ErrConnectionClosed), errorClassUnavailable},
+		{"rethinkdb connection error", r.RQLConnectionError{}, errorClassUnavailable},
+		{"rethinkdb runtime error", r.RQLQueryLogicError{}, errorClassQuery},
+		{"grpc unavailable", fmt.Errorf("frontier: %w", status.Error(codes.Unavailable, "connection refused")), errorClassUnavailable},
+		{"grpc deadline", status.Error(codes.DeadlineExceeded, "deadline"), errorClassTimeout},
+		{"grpc internal", status.Error(codes.Internal, "boom"), errorClassOther},
+		{"other", errors.New("boom"), errorClassOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorClass(tt.err); got != tt.want {
+				t.Errorf("errorClass() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
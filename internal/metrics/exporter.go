@@ -18,82 +18,287 @@ package metrics
 
 import (
 	"context"
-	frontierV1 "github.com/nlnwa/veidemann-api/go/frontier/v1"
-	"github.com/nlnwa/veidemann-metrics/internal/frontier"
-	"github.com/nlnwa/veidemann-metrics/internal/rethinkdb"
-	"log"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	collectorSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "exporter", "collector_success"),
+		"Whether the latest update of a collector succeeded.",
+		[]string{"collector"}, nil,
+	)
+
+	collectorLastSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "exporter", "collector_last_success_timestamp_seconds"),
+		"Unix time of the last successful update of a collector, or 0 if it never succeeded.",
+		[]string{"collector"}, nil,
+	)
+
+	upDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "", "up"),
+		"Whether the latest update of every collector succeeded.",
+		nil, nil,
+	)
+)
+
+// Mode determines when a collector is updated.
+type Mode string
+
+const (
+	// ModeBackground updates a collector at a fixed interval in the background.
+	ModeBackground Mode = "background"
+	// ModeScrape updates a collector synchronously on every scrape.
+	ModeScrape Mode = "scrape"
 )
 
+// CollectorConfig configures how an enabled collector is run.
+type CollectorConfig struct {
+	// Name is the registered name of the collector.
+	Name string
+	// Mode determines when the collector is updated. Defaults to ModeBackground.
+	Mode Mode
+	// Interval is the time between updates in background mode.
+	Interval time.Duration
+	// Timeout bounds the duration of a single update.
+	Timeout time.Duration
+	// CacheTTL is how long the result of an update is reused by scrape mode
+	// updates and by Update. Concurrent updates are always shared.
+	CacheTTL time.Duration
+}
+
+// collectorState is the immutable outcome of the latest update of a collector.
+type collectorState struct {
+	// metrics are the metrics of the last successful update.
+	metrics []prometheus.Metric
+	success bool
+	// lastSuccess is the time of the last successful update.
+	lastSuccess time.Time
+}
+
+// namedCollector is an enabled collector and the outcome of its latest update.
+type namedCollector struct {
+	CollectorConfig
+	Collector
+
+	state atomic.Pointer[collectorState]
+}
+
+// Exporter is a prometheus.Collector serving the metrics gathered by the most
+// recent update of every enabled collector.
+//
+// A collector that fails keeps serving the metrics of its last successful
+// update, while the failure is reported by the collector success and up metrics.
 type Exporter struct {
-	rethinkdb *rethinkdb.Query
-	frontier  *frontier.Client
+	collectors []*namedCollector
+	cache      *Cache
+	errors     *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+}
+
+// New creates a new Exporter running the configured collectors.
+func New(sources Sources, options Options, collectors ...CollectorConfig) (*Exporter, error) {
+	e := &Exporter{
+		cache: NewCache(context.Background()),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "exporter",
+			Name:      "collector_errors_total",
+			Help:      "Number of failed collector updates by error class.",
+		}, []string{"collector", "class"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "exporter",
+			Name:      "collector_duration_seconds",
+			Help:      "Duration of collector updates.",
+			Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+		}, []string{"collector"}),
+	}
+	for _, cfg := range collectors {
+		switch cfg.Mode {
+		case "":
+			cfg.Mode = ModeBackground
+		case ModeBackground, ModeScrape:
+		default:
+			return nil, fmt.Errorf("collector %s: unknown mode: %s", cfg.Name, cfg.Mode)
+		}
+		if cfg.Mode == ModeBackground && cfg.Interval <= 0 {
+			return nil, fmt.Errorf("collector %s: interval must be positive", cfg.Name)
+		}
+		if cfg.Timeout <= 0 {
+			return nil, fmt.Errorf("collector %s: timeout must be positive", cfg.Name)
+		}
+		c, err := newCollector(cfg.Name, sources, options)
+		if err != nil {
+			return nil, err
+		}
+		nc := &namedCollector{CollectorConfig: cfg, Collector: c}
+		nc.state.Store(new(collectorState))
+		e.collectors = append(e.collectors, nc)
+		for _, class := range errorClasses {
+			e.errors.WithLabelValues(cfg.Name, class)
+		}
+	}
+	return e, nil
+}
+
+// SetCache makes the exporter share cached results with other exporters using
+// the same cache. It must be called before the exporter is used.
+func (e *Exporter) SetCache(c *Cache) {
+	e.cache = c
 }
 
-// New creates a new Exporter
-func New(rethinkdb *rethinkdb.Query, frontier *frontier.Client) *Exporter {
-	return &Exporter{
-		rethinkdb,
-		frontier,
+// Describe implements prometheus.Collector.
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range e.collectors {
+		c.Describe(ch)
 	}
+	ch <- collectorSuccessDesc
+	ch <- collectorLastSuccessDesc
+	ch <- cacheAgeDesc
+	ch <- upDesc
+	e.errors.Describe(ch)
+	e.duration.Describe(ch)
 }
 
-func (e *Exporter) Run(interval time.Duration) {
-	registerCollectors(e.collectUriQueueLength)
-	go func() {
-		e.collectJobStatusJob()
-		for range time.Tick(interval) {
-			e.collectJobStatusJob()
+// Collect implements prometheus.Collector.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.errors.Collect(ch)
+	e.duration.Collect(ch)
+	up := 1.0
+	for _, c := range e.collectors {
+		state := c.state.Load()
+		for _, m := range state.metrics {
+			ch <- m
 		}
-	}()
+		if !state.success {
+			up = 0
+		}
+		ch <- prometheus.MustNewConstMetric(collectorSuccessDesc, prometheus.GaugeValue, boolToFloat64(state.success), c.Name)
+		ch <- prometheus.MustNewConstMetric(collectorLastSuccessDesc, prometheus.GaugeValue, timestampSeconds(state.lastSuccess), c.Name)
+		if age, ok := e.cache.age(c.Name); ok {
+			ch <- prometheus.MustNewConstMetric(cacheAgeDesc, prometheus.GaugeValue, age.Seconds(), c.Name)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, up)
 }
 
-func (e *Exporter) collectJobStatusJob() {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	err := e.rethinkdb.WalkLatestJobExecutionForCrawlJobs(ctx, collectJobStatus)
-	if err != nil {
-		log.Fatal(err)
+// Run updates every background collector at its configured interval and runs
+// collectors that follow their data source until ctx is done. It returns when
+// all collectors have stopped.
+func (e *Exporter) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, c := range e.collectors {
+		if f, ok := c.Collector.(follower); ok {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				f.Follow(ctx)
+			}()
+		}
+		if c.Mode != ModeBackground {
+			continue
+		}
+		wg.Add(1)
+		go func(c *namedCollector) {
+			defer wg.Done()
+			ticker := time.NewTicker(c.Interval)
+			defer ticker.Stop()
+			for {
+				e.update(ctx, c, false)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(c)
 	}
+	wg.Wait()
 }
 
-func (e *Exporter) collectUriQueueLength() float64 {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	count, err := e.frontier.QueueCountTotal(ctx)
+// Update runs one update of every collector concurrently and returns when all
+// updates are done.
+func (e *Exporter) Update(ctx context.Context) {
+	e.updateMatching(ctx, func(*namedCollector) bool { return true })
+}
+
+// updateMatching runs one update of every collector matching fn concurrently,
+// reusing cached results, and returns when all updates are done.
+func (e *Exporter) updateMatching(ctx context.Context, fn func(*namedCollector) bool) {
+	var wg sync.WaitGroup
+	for _, c := range e.collectors {
+		if !fn(c) {
+			continue
+		}
+		wg.Add(1)
+		go func(c *namedCollector) {
+			defer wg.Done()
+			e.update(ctx, c, true)
+		}(c)
+	}
+	wg.Wait()
+}
+
+// update runs one update of a collector and replaces its state. If cached is
+// true the update is shared through the cache.
+func (e *Exporter) update(ctx context.Context, c *namedCollector, cached bool) {
+	run := func(ctx context.Context) ([]prometheus.Metric, error) {
+		start := time.Now()
+		m, err := c.Update(ctx)
+		e.duration.WithLabelValues(c.Name).Observe(time.Since(start).Seconds())
+		if err != nil && !errors.Is(err, context.Canceled) {
+			class := errorClass(err)
+			log.Error().Err(err).Str("collector", c.Name).Str("class", class).Msg("Collector failed, serving last known good data")
+			e.errors.WithLabelValues(c.Name, class).Inc()
+		}
+		return m, err
+	}
+
+	var (
+		m        []prometheus.Metric
+		produced time.Time
+		err      error
+	)
+	if cached {
+		// The shared call is bounded by the timeout of the collector
+		m, produced, err = e.cache.get(ctx, c.Name, c.CacheTTL, c.Timeout, run)
+	} else {
+		updateCtx, cancel := context.WithTimeout(ctx, c.Timeout)
+		m, err = run(updateCtx)
+		cancel()
+		produced = time.Now()
+	}
+
+	prev := c.state.Load()
 	if err != nil {
-		log.Fatal(err)
+		if errors.Is(ctx.Err(), context.Canceled) {
+			// Interrupted by shutdown or an aborted scrape
+			return
+		}
+		c.state.Store(&collectorState{metrics: prev.metrics, lastSuccess: prev.lastSuccess})
+		return
 	}
-	return float64(count)
+	c.state.Store(&collectorState{metrics: m, success: true, lastSuccess: produced})
 }
 
-func collectJobStatus(jobState *frontierV1.JobExecutionStatus) {
-	name := jobState.GetJobId()
-	stateOrDefault := getOrDefault(jobState.GetExecutionsState())
-	JobStatus.WithLabelValues(name, "ABORTED_MANUAL").Set(stateOrDefault("ABORTED_MANUAL", 0))
-	JobStatus.WithLabelValues(name, "ABORTED_SIZE").Set(stateOrDefault("ABORTED_SIZE", 0))
-	JobStatus.WithLabelValues(name, "ABORTED_TIMEOUT").Set(stateOrDefault("ABORTED_TIMEOUT", 0))
-	JobStatus.WithLabelValues(name, "CREATED").Set(stateOrDefault("CREATED", 0))
-	JobStatus.WithLabelValues(name, "FAILED").Set(stateOrDefault("FAILED", 0))
-	JobStatus.WithLabelValues(name, "FETCHING").Set(stateOrDefault("FETCHING", 0))
-	JobStatus.WithLabelValues(name, "FINISHED").Set(stateOrDefault("FINISHED", 0))
-	JobStatus.WithLabelValues(name, "SLEEPING").Set(stateOrDefault("SLEEPING", 0))
-
-	JobSize.WithLabelValues(name, "documentsCrawled").Set(float64(jobState.GetDocumentsCrawled()))
-	JobSize.WithLabelValues(name, "documentsDenied").Set(float64(jobState.GetDocumentsDenied()))
-	JobSize.WithLabelValues(name, "documentsFailed").Set(float64(jobState.GetDocumentsFailed()))
-	JobSize.WithLabelValues(name, "documentsOutOfScope").Set(float64(jobState.GetDocumentsOutOfScope()))
-	JobSize.WithLabelValues(name, "documentsRetried").Set(float64(jobState.GetDocumentsRetried()))
-	JobSize.WithLabelValues(name, "urisCrawled").Set(float64(jobState.GetUrisCrawled()))
-	JobSize.WithLabelValues(name, "bytesCrawled").Set(float64(jobState.GetBytesCrawled()))
+func boolToFloat64(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
 }
 
-func getOrDefault(m map[string]int32) func(k string, v int32) float64 {
-	return func(k string, v int32) float64 {
-		if value, ok := m[k]; !ok {
-			return float64(v)
-		} else {
-			return float64(value)
-		}
+// timestampSeconds returns t as seconds since the Unix epoch, or 0 if t is zero.
+func timestampSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
 	}
+	return float64(t.UnixNano()) / 1e9
 }
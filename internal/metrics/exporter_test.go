@@ -0,0 +1,278 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	frontierV1 "github.com/nlnwa/veidemann-api/go/frontier/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type fakeJobExecutionSource []*frontierV1.JobExecutionStatus
+
+func (f fakeJobExecutionSource) WalkLatestJobExecutionForCrawlJobs(_ context.Context, fn func(*frontierV1.JobExecutionStatus)) error {
+	for _, jes := range f {
+		fn(jes)
+	}
+	return nil
+}
+
+func (f fakeJobExecutionSource) WalkRunningJobExecutions(_ context.Context, fn func(*frontierV1.JobExecutionStatus)) error {
+	for _, jes := range f {
+		switch jes.GetState() {
+		case frontierV1.JobExecutionStatus_CREATED, frontierV1.JobExecutionStatus_RUNNING:
+			fn(jes)
+		}
+	}
+	return nil
+}
+
+type fakeQueueSource int64
+
+func (f fakeQueueSource) QueueCountTotal(context.Context) (int64, error) {
+	return int64(f), nil
+}
+
+type blockingQueueSource struct{}
+
+func (blockingQueueSource) QueueCountTotal(ctx context.Context) (int64, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+type failingQueueSource struct {
+	count int64
+	err   error
+}
+
+func (f *failingQueueSource) QueueCountTotal(context.Context) (int64, error) {
+	return f.count, f.err
+}
+
+func newTestExporter(t *testing.T, sources Sources, collectors ...string) *Exporter {
+	t.Helper()
+	if len(collectors) == 0 {
+		collectors = []string{"jobs", "uri-queue"}
+	}
+	exp, err := New(sources, Options{}, testConfigs(collectors...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exp
+}
+
+func testConfigs(collectors ...string) []CollectorConfig {
+	var configs []CollectorConfig
+	for _, name := range collectors {
+		configs = append(configs, CollectorConfig{Name: name, Interval: time.Minute, Timeout: time.Second})
+	}
+	return configs
+}
+
+// seriesByLabel returns the number of series of the named metric by the value
+// of the given label.
+func seriesByLabel(t *testing.T, c prometheus.Collector, metric, label string) map[string]int {
+	t.Helper()
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	series := make(map[string]int)
+	for _, mf := range families {
+		if mf.GetName() != metric {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == label {
+					series[l.GetValue()]++
+				}
+			}
+		}
+	}
+	return series
+}
+
+func TestExporterCollect(t *testing.T) {
+	exp := newTestExporter(t, Sources{JobExecutions: fakeJobExecutionSource{
+		{
+			JobId:            "daily",
+			ExecutionsState:  map[string]int32{"FETCHING": 2, "FINISHED": 5},
+			DocumentsCrawled: 10,
+			BytesCrawled:     2048,
+			UrisCrawled:      12,
+		},
+	}, Frontier: fakeQueueSource(42)})
+
+	if n := testutil.CollectAndCount(exp, "veidemann_job_size_total"); n != 0 {
+		t.Errorf("expected no metrics before first collection, got %d", n)
+	}
+
+	exp.Update(context.Background())
+
+	expected := `
+# HELP veidemann_job_size_total Sizes for running jobs
+# TYPE veidemann_job_size_total gauge
+veidemann_job_size_total{job_name="daily",type="bytesCrawled"} 2048
+veidemann_job_size_total{job_name="daily",type="documentsCrawled"} 10
+veidemann_job_size_total{job_name="daily",type="documentsDenied"} 0
+veidemann_job_size_total{job_name="daily",type="documentsFailed"} 0
+veidemann_job_size_total{job_name="daily",type="documentsOutOfScope"} 0
+veidemann_job_size_total{job_name="daily",type="documentsRetried"} 0
+veidemann_job_size_total{job_name="daily",type="urisCrawled"} 12
+# HELP veidemann_job_status_total Status for running jobs
+# TYPE veidemann_job_status_total gauge
+veidemann_job_status_total{job_name="daily",status="ABORTED_MANUAL"} 0
+veidemann_job_status_total{job_name="daily",status="ABORTED_SIZE"} 0
+veidemann_job_status_total{job_name="daily",status="ABORTED_TIMEOUT"} 0
+veidemann_job_status_total{job_name="daily",status="CREATED"} 0
+veidemann_job_status_total{job_name="daily",status="FAILED"} 0
+veidemann_job_status_total{job_name="daily",status="FETCHING"} 2
+veidemann_job_status_total{job_name="daily",status="FINISHED"} 5
+veidemann_job_status_total{job_name="daily",status="SLEEPING"} 0
+# HELP veidemann_uri_queue_count Number of uris in queue.
+# TYPE veidemann_uri_queue_count gauge
+veidemann_uri_queue_count 42
+`
+	if err := testutil.CollectAndCompare(exp, strings.NewReader(expected),
+		"veidemann_job_size_total", "veidemann_job_status_total", "veidemann_uri_queue_count"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExporterRemovesStaleJobs(t *testing.T) {
+	source := fakeJobExecutionSource{
+		{JobId: "daily"},
+		{JobId: "weekly"},
+	}
+	exp := newTestExporter(t, Sources{JobExecutions: &source, Frontier: fakeQueueSource(0)})
+	exp.Update(context.Background())
+
+	// weekly is deleted, daily is renamed and two jobs share a name
+	source = fakeJobExecutionSource{
+		{JobId: "nightly"},
+		{JobId: "monthly"},
+		{JobId: "monthly"},
+	}
+	exp.Update(context.Background())
+
+	jobs := seriesByLabel(t, exp, "veidemann_job_size_total", "job_name")
+	want := map[string]int{"nightly": 7, "monthly": 7}
+	if !reflect.DeepEqual(jobs, want) {
+		t.Errorf("series per job = %v, want %v", jobs, want)
+	}
+}
+
+func TestExporterServesLastKnownGoodData(t *testing.T) {
+	source := &failingQueueSource{count: 42}
+	exp := newTestExporter(t, Sources{JobExecutions: fakeJobExecutionSource{}, Frontier: source})
+	exp.Update(context.Background())
+
+	source.count = 7
+	source.err = errors.New("connection refused")
+	exp.Update(context.Background())
+
+	expected := `
+# HELP veidemann_exporter_collector_errors_total Number of failed collector updates by error class.
+# TYPE veidemann_exporter_collector_errors_total counter
+veidemann_exporter_collector_errors_total{class="canceled",collector="jobs"} 0
+veidemann_exporter_collector_errors_total{class="canceled",collector="uri-queue"} 0
+veidemann_exporter_collector_errors_total{class="other",collector="jobs"} 0
+veidemann_exporter_collector_errors_total{class="other",collector="uri-queue"} 1
+veidemann_exporter_collector_errors_total{class="query",collector="jobs"} 0
+veidemann_exporter_collector_errors_total{class="query",collector="uri-queue"} 0
+veidemann_exporter_collector_errors_total{class="timeout",collector="jobs"} 0
+veidemann_exporter_collector_errors_total{class="timeout",collector="uri-queue"} 0
+veidemann_exporter_collector_errors_total{class="unavailable",collector="jobs"} 0
+veidemann_exporter_collector_errors_total{class="unavailable",collector="uri-queue"} 0
+# HELP veidemann_exporter_collector_success Whether the latest update of a collector succeeded.
+# TYPE veidemann_exporter_collector_success gauge
+veidemann_exporter_collector_success{collector="jobs"} 1
+veidemann_exporter_collector_success{collector="uri-queue"} 0
+# HELP veidemann_up Whether the latest update of every collector succeeded.
+# TYPE veidemann_up gauge
+veidemann_up 0
+# HELP veidemann_uri_queue_count Number of uris in queue.
+# TYPE veidemann_uri_queue_count gauge
+veidemann_uri_queue_count 42
+`
+	if err := testutil.CollectAndCompare(exp, strings.NewReader(expected),
+		"veidemann_exporter_collector_errors_total",
+		"veidemann_exporter_collector_success",
+		"veidemann_up",
+		"veidemann_uri_queue_count",
+	); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(exp, "veidemann_exporter_collector_duration_seconds"); n != 2 {
+		t.Errorf("expected duration histogram per collector, got %d", n)
+	}
+}
+
+func TestExporterEnabledCollectors(t *testing.T) {
+	exp := newTestExporter(t, Sources{JobExecutions: fakeJobExecutionSource{{JobId: "daily"}}}, "jobs")
+	exp.Update(context.Background())
+
+	if n := testutil.CollectAndCount(exp, "veidemann_uri_queue_count"); n != 0 {
+		t.Errorf("expected no metrics from disabled collector, got %d", n)
+	}
+	if n := testutil.CollectAndCount(exp, "veidemann_exporter_collector_success"); n != 1 {
+		t.Errorf("expected success metric for one collector, got %d", n)
+	}
+
+	if _, err := New(Sources{}, Options{}, testConfigs("uri-queue")...); err == nil {
+		t.Error("expected error creating collector without frontier")
+	}
+	if _, err := New(Sources{}, Options{}, testConfigs("no-such-collector")...); err == nil {
+		t.Error("expected error creating unknown collector")
+	}
+	if _, err := New(Sources{Frontier: fakeQueueSource(0)}, Options{}, CollectorConfig{Name: "uri-queue", Timeout: time.Second}); err == nil {
+		t.Error("expected error creating collector without interval")
+	}
+}
+
+func TestExporterAppliesTimeout(t *testing.T) {
+	exp, err := New(Sources{Frontier: blockingQueueSource{}}, Options{},
+		CollectorConfig{Name: "uri-queue", Interval: time.Minute, Timeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp.Update(context.Background())
+
+	if v := testutil.ToFloat64(exp.errors.WithLabelValues("uri-queue", errorClassTimeout)); v != 1 {
+		t.Errorf("expected one timeout error, got %v", v)
+	}
+}
+
+func TestExporterRunStopsOnCancel(t *testing.T) {
+	exp, err := New(Sources{Frontier: blockingQueueSource{}}, Options{},
+		CollectorConfig{Name: "uri-queue", Interval: time.Millisecond, Timeout: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		exp.Run(ctx)
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after cancellation")
+	}
+	for _, class := range errorClasses {
+		if v := testutil.ToFloat64(exp.errors.WithLabelValues("uri-queue", class)); v != 0 {
+			t.Errorf("expected no %s errors on shutdown, got %v", class, v)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+/*
+ * Copyright 2024 National Library of Norway.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+// fetchErrors maps the negative status codes Veidemann uses for failed
+// fetches, which are inherited from Heritrix, to error names.
+var fetchErrors = map[int32]string{
+	-1:    "dns_lookup_failed",
+	-2:    "connect_failed",
+	-3:    "connect_broken",
+	-4:    "http_timeout",
+	-5:    "runtime_exception",
+	-6:    "domain_lookup_failed",
+	-7:    "illegal_uri",
+	-8:    "retry_limit_reached",
+	-50:   "deferred",
+	-60:   "queue_failed",
+	-61:   "prerequisite_robots_failed",
+	-62:   "prerequisite_failed",
+	-63:   "prerequisite_unschedulable",
+	-404:  "empty_response",
+	-3000: "severe_error",
+	-4000: "chaff_detected",
+	-4001: "too_many_link_hops",
+	-4002: "too_many_embed_hops",
+	-5000: "out_of_scope",
+	-5001: "blocked_by_user",
+	-5002: "blocked_by_processor",
+	-5003: "quota_exceeded",
+	-5004: "runtime_exceeded",
+	-6000: "deleted_from_frontier",
+	-7000: "thread_killed",
+	-9998: "precluded_by_robots",
+}
+
+// fetchErrorName returns the name of the error given by a negative status
+// code, or "unknown" if the code is not known.
+func fetchErrorName(statusCode int32) string {
+	if name, ok := fetchErrors[statusCode]; ok {
+		return name
+	}
+	return "unknown"
+}
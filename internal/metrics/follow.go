@@ -0,0 +1,110 @@
+/*
+ * Copyright 2024 National Library of Norway.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/nlnwa/veidemann-metrics/internal/follow"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// errNotFollowing is returned by updates of a collector that is not following
+// its data source.
+var errNotFollowing = errors.New("not following data source")
+
+// followState tracks whether a collector is following its data source.
+type followState struct {
+	mu        sync.Mutex
+	following bool
+	err       error
+	// resubscriptions is the number of times following was resumed after
+	// stopping, each of which may have missed changes.
+	resubscriptions int
+}
+
+// follow calls fn until ctx is done, resubscribing with backoff when it
+// returns, and tracks whether the data source is being followed.
+func (s *followState) follow(ctx context.Context, name string, fn func(ctx context.Context) error) {
+	subscribed := false
+	follow.Run(ctx, name, func(ctx context.Context) error {
+		s.mu.Lock()
+		s.following = true
+		s.err = nil
+		if subscribed {
+			s.resubscriptions++
+		}
+		subscribed = true
+		s.mu.Unlock()
+
+		err := fn(ctx)
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		} else if err == nil {
+			err = errors.New("closed")
+		}
+		s.set(false, err)
+		return err
+	})
+}
+
+func (s *followState) set(following bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.following = following
+	s.err = err
+}
+
+// check returns an error unless the data source is being followed.
+func (s *followState) check() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.following {
+		return nil
+	}
+	if s.err != nil {
+		return fmt.Errorf("%w: %w", errNotFollowing, s.err)
+	}
+	return errNotFollowing
+}
+
+// resubscribed returns the number of times following was resumed after
+// stopping.
+func (s *followState) resubscribed() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resubscriptions
+}
+
+// collectMetrics returns the current metrics of the given collectors.
+func collectMetrics(collectors ...prometheus.Collector) []prometheus.Metric {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		for _, c := range collectors {
+			c.Collect(ch)
+		}
+		close(ch)
+	}()
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
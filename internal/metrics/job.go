@@ -0,0 +1,216 @@
+/*
+ * Copyright 2018 National Library of Norway.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	frontierV1 "github.com/nlnwa/veidemann-api/go/frontier/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	jobStatusDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "job", "status_total"),
+		"Status for running jobs",
+		[]string{"job_name", "status"}, nil,
+	)
+
+	jobSizeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "job", "size_total"),
+		"Sizes for running jobs",
+		[]string{"job_name", "type"}, nil,
+	)
+
+	jobExecutionStateDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "job_execution", "state"),
+		"State of the latest execution of a job, 1 for the current state and 0 for the others.",
+		[]string{"job_name", "state"}, nil,
+	)
+
+	jobExecutionDesiredStateDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "job_execution", "desired_state"),
+		"Requested state of the latest execution of a job, 1 for the requested state and 0 for the others. UNDEFINED means no state change is requested.",
+		[]string{"job_name", "state"}, nil,
+	)
+
+	jobExecutionStartDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "job_execution", "start_timestamp_seconds"),
+		"Start time of the latest execution of a job in seconds since the Unix epoch.",
+		[]string{"job_name"}, nil,
+	)
+
+	jobExecutionEndDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "job_execution", "end_timestamp_seconds"),
+		"End time of the latest execution of a job in seconds since the Unix epoch. Not exported until the execution has ended.",
+		[]string{"job_name"}, nil,
+	)
+
+	jobExecutionDurationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "job_execution", "duration_seconds"),
+		"Time the latest execution of a job has been running, or ran if it has ended.",
+		[]string{"job_name"}, nil,
+	)
+)
+
+// jobExecutionStates are the states of a job execution in enum order.
+var jobExecutionStates = func() []frontierV1.JobExecutionStatus_State {
+	states := make([]frontierV1.JobExecutionStatus_State, 0, len(frontierV1.JobExecutionStatus_State_name))
+	for n := range frontierV1.JobExecutionStatus_State_name {
+		states = append(states, frontierV1.JobExecutionStatus_State(n))
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i] < states[j] })
+	return states
+}()
+
+func init() {
+	registerCollector("jobs", true, newJobCollector)
+}
+
+// jobCollector collects metrics of the latest job execution of every crawl job.
+type jobCollector struct {
+	source JobExecutionSource
+	now    func() time.Time
+
+	// jobs is the set of job names exported by the previous update.
+	jobs map[string]struct{}
+}
+
+func newJobCollector(sources Sources, _ Options) (Collector, error) {
+	if sources.JobExecutions == nil {
+		return nil, errors.New("no database configured")
+	}
+	return &jobCollector{source: sources.JobExecutions, now: time.Now}, nil
+}
+
+func (c *jobCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- jobStatusDesc
+	ch <- jobSizeDesc
+	ch <- jobExecutionStateDesc
+	ch <- jobExecutionDesiredStateDesc
+	ch <- jobExecutionStartDesc
+	ch <- jobExecutionEndDesc
+	ch <- jobExecutionDurationDesc
+}
+
+// Update returns the metrics of the latest job executions. Since the metrics
+// are built from scratch, series of jobs that were deleted or renamed since
+// the previous update are no longer exported.
+func (c *jobCollector) Update(ctx context.Context) ([]prometheus.Metric, error) {
+	var metrics []prometheus.Metric
+	jobs := make(map[string]struct{})
+	now := c.now()
+	err := c.source.WalkLatestJobExecutionForCrawlJobs(ctx, func(jobState *frontierV1.JobExecutionStatus) {
+		name := jobState.GetJobId()
+		if _, ok := jobs[name]; ok {
+			// Two crawl jobs sharing a name would yield duplicate series and fail the scrape
+			log.Warn().Str("job", name).Str("jobExecutionId", jobState.GetId()).Msg("Skipping job execution of job with duplicate name")
+			return
+		}
+		jobs[name] = struct{}{}
+		metrics = append(metrics, collectJobStatus(jobState)...)
+		metrics = append(metrics, collectJobLifecycle(jobState, now)...)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query job executions: %w", err)
+	}
+	for name := range c.jobs {
+		if _, ok := jobs[name]; !ok {
+			log.Info().Str("job", name).Msg("Job is gone, removing its series")
+		}
+	}
+	c.jobs = jobs
+	return metrics, nil
+}
+
+func collectJobStatus(jobState *frontierV1.JobExecutionStatus) []prometheus.Metric {
+	return jobExecutionMetrics(jobStatusDesc, jobSizeDesc, jobState, jobState.GetJobId())
+}
+
+// collectJobLifecycle returns the state, desired state, start and end time and
+// duration of the latest execution of a job. The duration of an execution
+// that has not ended is measured until now.
+func collectJobLifecycle(jobState *frontierV1.JobExecutionStatus, now time.Time) []prometheus.Metric {
+	name := jobState.GetJobId()
+	metrics := make([]prometheus.Metric, 0, 2*len(jobExecutionStates)+3)
+	for _, state := range jobExecutionStates {
+		metrics = append(metrics,
+			prometheus.MustNewConstMetric(jobExecutionStateDesc, prometheus.GaugeValue,
+				boolToFloat64(jobState.GetState() == state), name, state.String()),
+			prometheus.MustNewConstMetric(jobExecutionDesiredStateDesc, prometheus.GaugeValue,
+				boolToFloat64(jobState.GetDesiredState() == state), name, state.String()),
+		)
+	}
+	if jobState.GetStartTime() == nil {
+		return metrics
+	}
+	start := jobState.GetStartTime().AsTime()
+	end := now
+	metrics = append(metrics, prometheus.MustNewConstMetric(jobExecutionStartDesc, prometheus.GaugeValue, timestampSeconds(start), name))
+	if jobState.GetEndTime() != nil {
+		end = jobState.GetEndTime().AsTime()
+		metrics = append(metrics, prometheus.MustNewConstMetric(jobExecutionEndDesc, prometheus.GaugeValue, timestampSeconds(end), name))
+	}
+	metrics = append(metrics, prometheus.MustNewConstMetric(jobExecutionDurationDesc, prometheus.GaugeValue, end.Sub(start).Seconds(), name))
+	return metrics
+}
+
+// jobExecutionMetrics returns the crawl execution state counts and sizes of a
+// job execution as metrics of statusDesc and sizeDesc, labelled with
+// labelValues followed by the state or size type.
+func jobExecutionMetrics(statusDesc, sizeDesc *prometheus.Desc, jobState *frontierV1.JobExecutionStatus, labelValues ...string) []prometheus.Metric {
+	stateOrDefault := getOrDefault(jobState.GetExecutionsState())
+	status := func(state string) prometheus.Metric {
+		return prometheus.MustNewConstMetric(statusDesc, prometheus.GaugeValue, stateOrDefault(state, 0), append(labelValues, state)...)
+	}
+	size := func(typ string, value int64) prometheus.Metric {
+		return prometheus.MustNewConstMetric(sizeDesc, prometheus.GaugeValue, float64(value), append(labelValues, typ)...)
+	}
+	return []prometheus.Metric{
+		status("ABORTED_MANUAL"),
+		status("ABORTED_SIZE"),
+		status("ABORTED_TIMEOUT"),
+		status("CREATED"),
+		status("FAILED"),
+		status("FETCHING"),
+		status("FINISHED"),
+		status("SLEEPING"),
+
+		size("documentsCrawled", jobState.GetDocumentsCrawled()),
+		size("documentsDenied", jobState.GetDocumentsDenied()),
+		size("documentsFailed", jobState.GetDocumentsFailed()),
+		size("documentsOutOfScope", jobState.GetDocumentsOutOfScope()),
+		size("documentsRetried", jobState.GetDocumentsRetried()),
+		size("urisCrawled", jobState.GetUrisCrawled()),
+		size("bytesCrawled", jobState.GetBytesCrawled()),
+	}
+}
+
+func getOrDefault(m map[string]int32) func(k string, v int32) float64 {
+	return func(k string, v int32) float64 {
+		if value, ok := m[k]; !ok {
+			return float64(v)
+		} else {
+			return float64(value)
+		}
+	}
+}
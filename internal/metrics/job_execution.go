@@ -0,0 +1,85 @@
+/*
+ * Copyright 2024 National Library of Norway.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	frontierV1 "github.com/nlnwa/veidemann-api/go/frontier/v1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	jobExecutionStatusDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "job_execution", "status_total"),
+		"Status for running job executions",
+		[]string{"job_name", "execution_id", "status"}, nil,
+	)
+
+	jobExecutionSizeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "job_execution", "size_total"),
+		"Sizes for running job executions",
+		[]string{"job_name", "execution_id", "type"}, nil,
+	)
+
+	jobRunningExecutionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "job", "running_executions"),
+		"Number of concurrently running executions of a job.",
+		[]string{"job_name"}, nil,
+	)
+)
+
+func init() {
+	registerCollector("job-executions", false, newJobExecutionCollector)
+}
+
+// jobExecutionCollector collects metrics of every job execution that has not
+// terminated, including older executions running alongside the latest one.
+type jobExecutionCollector struct {
+	source JobExecutionSource
+}
+
+func newJobExecutionCollector(sources Sources, _ Options) (Collector, error) {
+	if sources.JobExecutions == nil {
+		return nil, errors.New("no database configured")
+	}
+	return &jobExecutionCollector{source: sources.JobExecutions}, nil
+}
+
+func (c *jobExecutionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- jobExecutionStatusDesc
+	ch <- jobExecutionSizeDesc
+	ch <- jobRunningExecutionsDesc
+}
+
+func (c *jobExecutionCollector) Update(ctx context.Context) ([]prometheus.Metric, error) {
+	var metrics []prometheus.Metric
+	running := make(map[string]int)
+	err := c.source.WalkRunningJobExecutions(ctx, func(jes *frontierV1.JobExecutionStatus) {
+		running[jes.GetJobId()]++
+		metrics = append(metrics, jobExecutionMetrics(jobExecutionStatusDesc, jobExecutionSizeDesc, jes, jes.GetJobId(), jes.GetId())...)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query running job executions: %w", err)
+	}
+	for name, n := range running {
+		metrics = append(metrics, prometheus.MustNewConstMetric(jobRunningExecutionsDesc, prometheus.GaugeValue, float64(n), name))
+	}
+	return metrics, nil
+}
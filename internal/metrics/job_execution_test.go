@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	frontierV1 "github.com/nlnwa/veidemann-api/go/frontier/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestJobExecutionCollector(t *testing.T) {
+	exp := newTestExporter(t, Sources{JobExecutions: fakeJobExecutionSource{
+		{Id: "jes-1", JobId: "daily", State: frontierV1.JobExecutionStatus_RUNNING, UrisCrawled: 10},
+		{Id: "jes-2", JobId: "daily", State: frontierV1.JobExecutionStatus_RUNNING, UrisCrawled: 20},
+		{Id: "jes-3", JobId: "daily", State: frontierV1.JobExecutionStatus_FINISHED, UrisCrawled: 30},
+		{Id: "jes-4", JobId: "weekly", State: frontierV1.JobExecutionStatus_CREATED},
+	}}, "job-executions")
+	exp.Update(context.Background())
+
+	expected := `
+# HELP veidemann_job_running_executions Number of concurrently running executions of a job.
+# TYPE veidemann_job_running_executions gauge
+veidemann_job_running_executions{job_name="daily"} 2
+veidemann_job_running_executions{job_name="weekly"} 1
+`
+	if err := testutil.CollectAndCompare(exp, strings.NewReader(expected), "veidemann_job_running_executions"); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(exp, "veidemann_job_execution_size_total"); n != 3*7 {
+		t.Errorf("expected sizes of three running executions, got %d series", n)
+	}
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright 2024 National Library of Norway.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	frontierV1 "github.com/nlnwa/veidemann-api/go/frontier/v1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	jobLastSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "job", "last_success_timestamp_seconds"),
+		"End time of the latest execution of a job that finished, in seconds since the Unix epoch.",
+		[]string{"job_name"}, nil,
+	)
+
+	jobLastFailureDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "job", "last_failure_timestamp_seconds"),
+		"End time of the latest execution of a job that failed, died or was aborted, in seconds since the Unix epoch.",
+		[]string{"job_name"}, nil,
+	)
+)
+
+var (
+	// successfulJobExecutionStates are the terminal states of a job execution that completed.
+	successfulJobExecutionStates = []frontierV1.JobExecutionStatus_State{
+		frontierV1.JobExecutionStatus_FINISHED,
+	}
+	// failedJobExecutionStates are the terminal states of a job execution that did not complete.
+	failedJobExecutionStates = []frontierV1.JobExecutionStatus_State{
+		frontierV1.JobExecutionStatus_ABORTED_MANUAL,
+		frontierV1.JobExecutionStatus_FAILED,
+		frontierV1.JobExecutionStatus_DIED,
+	}
+)
+
+func init() {
+	registerCollector("job-last-outcome", false, newJobLastOutcomeCollector)
+}
+
+// jobLastOutcomeCollector collects the time every crawl job last completed
+// successfully and last failed, regardless of the state of its latest execution.
+type jobLastOutcomeCollector struct {
+	source JobHistorySource
+}
+
+func newJobLastOutcomeCollector(sources Sources, _ Options) (Collector, error) {
+	if sources.JobHistory == nil {
+		return nil, errors.New("no database configured")
+	}
+	return &jobLastOutcomeCollector{source: sources.JobHistory}, nil
+}
+
+func (c *jobLastOutcomeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- jobLastSuccessDesc
+	ch <- jobLastFailureDesc
+}
+
+// Update returns the end time of the latest successful and failed execution of
+// every crawl job. Jobs without such an execution have no series, and crawl
+// jobs sharing a name are reported by the latest of their executions.
+func (c *jobLastOutcomeCollector) Update(ctx context.Context) ([]prometheus.Metric, error) {
+	success := make(map[string]time.Time)
+	failure := make(map[string]time.Time)
+	err := c.source.WalkLatestEndedJobExecutions(ctx, terminalJobExecutionStates, func(jes *frontierV1.JobExecutionStatus) {
+		if jes.GetEndTime() == nil {
+			return
+		}
+		latest := failure
+		if isSuccessfulJobExecution(jes) {
+			latest = success
+		}
+		if end := jes.GetEndTime().AsTime(); end.After(latest[jes.GetJobId()]) {
+			latest[jes.GetJobId()] = end
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query last ended job executions: %w", err)
+	}
+
+	metrics := make([]prometheus.Metric, 0, len(success)+len(failure))
+	for name, end := range success {
+		metrics = append(metrics, prometheus.MustNewConstMetric(jobLastSuccessDesc, prometheus.GaugeValue, timestampSeconds(end), name))
+	}
+	for name, end := range failure {
+		metrics = append(metrics, prometheus.MustNewConstMetric(jobLastFailureDesc, prometheus.GaugeValue, timestampSeconds(end), name))
+	}
+	return metrics, nil
+}
+
+// isSuccessfulJobExecution reports whether a job execution completed.
+func isSuccessfulJobExecution(jes *frontierV1.JobExecutionStatus) bool {
+	for _, state := range successfulJobExecutionStates {
+		if jes.GetState() == state {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	frontierV1 "github.com/nlnwa/veidemann-api/go/frontier/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeJobHistorySource is the complete history of job executions.
+type fakeJobHistorySource []*frontierV1.JobExecutionStatus
+
+func (f fakeJobHistorySource) WalkLatestEndedJobExecutions(_ context.Context, states []frontierV1.JobExecutionStatus_State, fn func(*frontierV1.JobExecutionStatus)) error {
+	type key struct {
+		job   string
+		state frontierV1.JobExecutionStatus_State
+	}
+	latest := make(map[key]*frontierV1.JobExecutionStatus)
+	for _, jes := range f {
+		if jes.GetEndTime() == nil {
+			continue
+		}
+		for _, state := range states {
+			if jes.GetState() != state {
+				continue
+			}
+			k := key{jes.GetJobId(), state}
+			if cur, ok := latest[k]; !ok || jes.GetEndTime().AsTime().After(cur.GetEndTime().AsTime()) {
+				latest[k] = jes
+			}
+		}
+	}
+	for _, jes := range latest {
+		fn(jes)
+	}
+	return nil
+}
+
+func (f fakeJobHistorySource) CountJobExecutions(_ context.Context, states []frontierV1.JobExecutionStatus_State, since time.Time, fn func(string, frontierV1.JobExecutionStatus_State, int64)) error {
+	type key struct {
+		job   string
+		state frontierV1.JobExecutionStatus_State
+	}
+	counts := make(map[key]int64)
+	for _, jes := range f {
+		if jes.GetStartTime().AsTime().Before(since) {
+			continue
+		}
+		for _, state := range states {
+			if jes.GetState() == state {
+				counts[key{jes.GetJobId(), state}]++
+			}
+		}
+	}
+	for k, n := range counts {
+		fn(k.job, k.state, n)
+	}
+	return nil
+}
+
+func testJobExecution(job string, state frontierV1.JobExecutionStatus_State, start time.Time, d time.Duration) *frontierV1.JobExecutionStatus {
+	jes := &frontierV1.JobExecutionStatus{JobId: job, State: state, StartTime: timestamppb.New(start)}
+	if d > 0 {
+		jes.EndTime = timestamppb.New(start.Add(d))
+	}
+	return jes
+}
+
+func TestJobLastOutcomeCollector(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	exp := newTestExporter(t, Sources{JobHistory: fakeJobHistorySource{
+		testJobExecution("daily", frontierV1.JobExecutionStatus_FINISHED, day, time.Hour),
+		testJobExecution("daily", frontierV1.JobExecutionStatus_FINISHED, day.AddDate(0, 0, 1), time.Hour),
+		testJobExecution("daily", frontierV1.JobExecutionStatus_FAILED, day.AddDate(0, 0, 2), time.Hour),
+		testJobExecution("daily", frontierV1.JobExecutionStatus_RUNNING, day.AddDate(0, 0, 3), 0),
+		testJobExecution("weekly", frontierV1.JobExecutionStatus_ABORTED_MANUAL, day, time.Minute),
+		testJobExecution("weekly", frontierV1.JobExecutionStatus_DIED, day.AddDate(0, 0, 7), 2*time.Minute),
+		testJobExecution("weekly", frontierV1.JobExecutionStatus_DIED, day.AddDate(0, 0, 14), 0),
+	}}, "job-last-outcome")
+	exp.Update(context.Background())
+
+	expected := `
+# HELP veidemann_job_last_failure_timestamp_seconds End time of the latest execution of a job that failed, died or was aborted, in seconds since the Unix epoch.
+# TYPE veidemann_job_last_failure_timestamp_seconds gauge
+veidemann_job_last_failure_timestamp_seconds{job_name="daily"} 1.7094276e+09
+veidemann_job_last_failure_timestamp_seconds{job_name="weekly"} 1.70985612e+09
+# HELP veidemann_job_last_success_timestamp_seconds End time of the latest execution of a job that finished, in seconds since the Unix epoch.
+# TYPE veidemann_job_last_success_timestamp_seconds gauge
+veidemann_job_last_success_timestamp_seconds{job_name="daily"} 1.7093412e+09
+`
+	if err := testutil.CollectAndCompare(exp, strings.NewReader(expected),
+		"veidemann_job_last_failure_timestamp_seconds", "veidemann_job_last_success_timestamp_seconds"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright 2024 National Library of Norway.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	frontierV1 "github.com/nlnwa/veidemann-api/go/frontier/v1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	jobExecutionsTotalDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "job", "executions_total"),
+		"Number of job executions of a job that ended in a state.",
+		[]string{"job_name", "state"}, nil,
+	)
+
+	jobExecutionsWindowDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "job", "executions"),
+		"Number of job executions of a job started within the window that ended in a state.",
+		[]string{"job_name", "state"}, nil,
+	)
+)
+
+// terminalJobExecutionStates are the states of job executions that have ended.
+var terminalJobExecutionStates = append(append([]frontierV1.JobExecutionStatus_State{},
+	successfulJobExecutionStates...), failedJobExecutionStates...)
+
+func init() {
+	registerCollector("job-outcomes", false, newJobOutcomesCollector)
+}
+
+// jobOutcomesCollector counts the job executions of every crawl job by the
+// state they ended in.
+type jobOutcomesCollector struct {
+	source JobHistorySource
+	// window limits the count to executions started within it, or counts the
+	// whole history if zero.
+	window time.Duration
+	now    func() time.Time
+}
+
+func newJobOutcomesCollector(sources Sources, options Options) (Collector, error) {
+	if sources.JobHistory == nil {
+		return nil, errors.New("no database configured")
+	}
+	if options.JobOutcomesWindow < 0 {
+		return nil, errors.New("window must not be negative")
+	}
+	return &jobOutcomesCollector{source: sources.JobHistory, window: options.JobOutcomesWindow, now: time.Now}, nil
+}
+
+func (c *jobOutcomesCollector) Describe(ch chan<- *prometheus.Desc) {
+	if c.window > 0 {
+		ch <- jobExecutionsWindowDesc
+	} else {
+		ch <- jobExecutionsTotalDesc
+	}
+}
+
+// Update returns the number of ended job executions of every job that has
+// any, with a series for every terminal state. Counted over the whole history
+// the values are counters, which only decrease if executions are deleted from
+// the history. Counts within a window decrease as executions leave the window
+// and are exported as gauges under their own name.
+func (c *jobOutcomesCollector) Update(ctx context.Context) ([]prometheus.Metric, error) {
+	var since time.Time
+	desc, valueType := jobExecutionsTotalDesc, prometheus.CounterValue
+	if c.window > 0 {
+		since = c.now().Add(-c.window)
+		desc, valueType = jobExecutionsWindowDesc, prometheus.GaugeValue
+	}
+
+	counts := make(map[string]map[frontierV1.JobExecutionStatus_State]int64)
+	err := c.source.CountJobExecutions(ctx, terminalJobExecutionStates, since, func(job string, state frontierV1.JobExecutionStatus_State, count int64) {
+		if counts[job] == nil {
+			counts[job] = make(map[frontierV1.JobExecutionStatus_State]int64)
+		}
+		// Crawl jobs sharing a name are counted together
+		counts[job][state] += count
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count job executions: %w", err)
+	}
+
+	metrics := make([]prometheus.Metric, 0, len(counts)*len(terminalJobExecutionStates))
+	for job, states := range counts {
+		for _, state := range terminalJobExecutionStates {
+			metrics = append(metrics, prometheus.MustNewConstMetric(desc, valueType, float64(states[state]), job, state.String()))
+		}
+	}
+	return metrics, nil
+}
@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	frontierV1 "github.com/nlnwa/veidemann-api/go/frontier/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestJobOutcomesCollector(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	source := fakeJobHistorySource{
+		testJobExecution("daily", frontierV1.JobExecutionStatus_FINISHED, day, time.Hour),
+		testJobExecution("daily", frontierV1.JobExecutionStatus_FINISHED, day.AddDate(0, 0, 1), time.Hour),
+		testJobExecution("daily", frontierV1.JobExecutionStatus_FAILED, day.AddDate(0, 0, 2), time.Hour),
+		testJobExecution("daily", frontierV1.JobExecutionStatus_RUNNING, day.AddDate(0, 0, 3), 0),
+		testJobExecution("weekly", frontierV1.JobExecutionStatus_DIED, day, time.Minute),
+	}
+
+	tests := []struct {
+		name     string
+		window   time.Duration
+		expected string
+	}{
+		{
+			name: "whole history",
+			expected: `
+# HELP veidemann_job_executions_total Number of job executions of a job that ended in a state.
+# TYPE veidemann_job_executions_total counter
+veidemann_job_executions_total{job_name="daily",state="ABORTED_MANUAL"} 0
+veidemann_job_executions_total{job_name="daily",state="DIED"} 0
+veidemann_job_executions_total{job_name="daily",state="FAILED"} 1
+veidemann_job_executions_total{job_name="daily",state="FINISHED"} 2
+veidemann_job_executions_total{job_name="weekly",state="ABORTED_MANUAL"} 0
+veidemann_job_executions_total{job_name="weekly",state="DIED"} 1
+veidemann_job_executions_total{job_name="weekly",state="FAILED"} 0
+veidemann_job_executions_total{job_name="weekly",state="FINISHED"} 0
+`,
+		},
+		{
+			name:   "window",
+			window: 48 * time.Hour,
+			expected: `
+# HELP veidemann_job_executions Number of job executions of a job started within the window that ended in a state.
+# TYPE veidemann_job_executions gauge
+veidemann_job_executions{job_name="daily",state="ABORTED_MANUAL"} 0
+veidemann_job_executions{job_name="daily",state="DIED"} 0
+veidemann_job_executions{job_name="daily",state="FAILED"} 1
+veidemann_job_executions{job_name="daily",state="FINISHED"} 1
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp, err := New(Sources{JobHistory: source}, Options{JobOutcomesWindow: tt.window}, testConfigs("job-outcomes")...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			outcomes, _ := exp.collectors[0].Collector.(*jobOutcomesCollector)
+			outcomes.now = func() time.Time { return day.AddDate(0, 0, 3) }
+			exp.Update(context.Background())
+
+			if err := testutil.CollectAndCompare(exp, strings.NewReader(tt.expected), "veidemann_job_executions_total", "veidemann_job_executions"); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright 2024 National Library of Norway.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	frontierV1 "github.com/nlnwa/veidemann-api/go/frontier/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	jobProgressLastChangeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "job_progress", "last_change_timestamp_seconds"),
+		"Last time the uris crawled, documents crawled or bytes crawled of the latest execution of a job changed, in seconds since the Unix epoch.",
+		[]string{"job_name"}, nil,
+	)
+
+	jobProgressStalledDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "job_progress", "stalled"),
+		"Whether the latest execution of a job is running without progress for longer than the stall duration.",
+		[]string{"job_name"}, nil,
+	)
+)
+
+func init() {
+	registerCollector("job-progress", false, newJobProgressCollector)
+}
+
+// jobProgress is the progress of a job execution when it was last seen to change.
+type jobProgress struct {
+	urisCrawled      int64
+	documentsCrawled int64
+	bytesCrawled     int64
+	lastChange       time.Time
+}
+
+// jobProgressCollector detects running job executions that have stopped
+// crawling by following their size counters across updates.
+type jobProgressCollector struct {
+	jobs   JobExecutionSource
+	source CrawlExecutionSource
+	// stallAfter is how long a running job execution may go without progress
+	// before it is stalled.
+	stallAfter time.Duration
+	now        func() time.Time
+
+	// progress maps job execution ids to their progress in the previous update.
+	progress map[string]jobProgress
+}
+
+func newJobProgressCollector(sources Sources, options Options) (Collector, error) {
+	if sources.JobExecutions == nil || sources.CrawlExecutions == nil {
+		return nil, errors.New("no database configured")
+	}
+	if options.ProgressStallAfter <= 0 {
+		return nil, errors.New("stall duration must be positive")
+	}
+	return &jobProgressCollector{
+		jobs:       sources.JobExecutions,
+		source:     sources.CrawlExecutions,
+		stallAfter: options.ProgressStallAfter,
+		now:        time.Now,
+		progress:   make(map[string]jobProgress),
+	}, nil
+}
+
+func (c *jobProgressCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- jobProgressLastChangeDesc
+	ch <- jobProgressStalledDesc
+}
+
+// Update returns the last time the latest execution of every job made
+// progress and whether it has stalled.
+//
+// An execution seen for the first time, e.g. after the exporter restarted, is
+// assumed to have last made progress when one of its crawl executions last
+// changed, or when it ended or started if that is unknown.
+func (c *jobProgressCollector) Update(ctx context.Context) ([]prometheus.Metric, error) {
+	var latest []*frontierV1.JobExecutionStatus
+	jobs := make(map[string]struct{})
+	err := c.jobs.WalkLatestJobExecutionForCrawlJobs(ctx, func(jes *frontierV1.JobExecutionStatus) {
+		if _, ok := jobs[jes.GetJobId()]; ok {
+			log.Warn().Str("job", jes.GetJobId()).Str("jobExecutionId", jes.GetId()).Msg("Skipping job execution of job with duplicate name")
+			return
+		}
+		jobs[jes.GetJobId()] = struct{}{}
+		latest = append(latest, jes)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query job executions: %w", err)
+	}
+
+	now := c.now()
+	progress := make(map[string]jobProgress, len(latest))
+	metrics := make([]prometheus.Metric, 0, 2*len(latest))
+	for _, jes := range latest {
+		p, ok := c.progress[jes.GetId()]
+		if !ok {
+			lastChange, err := c.seed(ctx, jes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get last change of job execution %s: %w", jes.GetId(), err)
+			}
+			p = jobProgress{
+				urisCrawled:      jes.GetUrisCrawled(),
+				documentsCrawled: jes.GetDocumentsCrawled(),
+				bytesCrawled:     jes.GetBytesCrawled(),
+				lastChange:       lastChange,
+			}
+		} else if p.urisCrawled != jes.GetUrisCrawled() || p.documentsCrawled != jes.GetDocumentsCrawled() || p.bytesCrawled != jes.GetBytesCrawled() {
+			p = jobProgress{
+				urisCrawled:      jes.GetUrisCrawled(),
+				documentsCrawled: jes.GetDocumentsCrawled(),
+				bytesCrawled:     jes.GetBytesCrawled(),
+				lastChange:       now,
+			}
+		}
+		progress[jes.GetId()] = p
+
+		stalled := jes.GetState() == frontierV1.JobExecutionStatus_RUNNING && now.Sub(p.lastChange) >= c.stallAfter
+		metrics = append(metrics,
+			prometheus.MustNewConstMetric(jobProgressLastChangeDesc, prometheus.GaugeValue, timestampSeconds(p.lastChange), jes.GetJobId()),
+			prometheus.MustNewConstMetric(jobProgressStalledDesc, prometheus.GaugeValue, boolToFloat64(stalled), jes.GetJobId()),
+		)
+	}
+	c.progress = progress
+	return metrics, nil
+}
+
+// seed returns the time a job execution is assumed to have last made progress
+// when it is seen for the first time.
+func (c *jobProgressCollector) seed(ctx context.Context, jes *frontierV1.JobExecutionStatus) (time.Time, error) {
+	if jes.GetState() == frontierV1.JobExecutionStatus_RUNNING {
+		lastChange, err := c.source.LastCrawlExecutionChange(ctx, jes.GetId())
+		if err != nil {
+			return time.Time{}, err
+		}
+		if !lastChange.IsZero() {
+			return lastChange, nil
+		}
+	}
+	if jes.GetEndTime() != nil {
+		return jes.GetEndTime().AsTime(), nil
+	}
+	return jes.GetStartTime().AsTime(), nil
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	frontierV1 "github.com/nlnwa/veidemann-api/go/frontier/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestJobProgressCollector(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	now := start.Add(3 * time.Hour)
+	jobs := fakeJobExecutionSource{
+		{Id: "jes-1", JobId: "daily", State: frontierV1.JobExecutionStatus_RUNNING, StartTime: timestamppb.New(start), UrisCrawled: 10},
+		{Id: "jes-2", JobId: "weekly", State: frontierV1.JobExecutionStatus_RUNNING, StartTime: timestamppb.New(start), UrisCrawled: 10},
+		{Id: "jes-3", JobId: "monthly", State: frontierV1.JobExecutionStatus_FINISHED, StartTime: timestamppb.New(start), EndTime: timestamppb.New(start.Add(time.Hour))},
+	}
+	sources := Sources{
+		JobExecutions: &jobs,
+		CrawlExecutions: fakeCrawlExecutionSource{
+			// daily last progressed an hour before the exporter started, weekly two hours before
+			{JobExecutionId: "jes-1", LastChangeTime: timestamppb.New(now.Add(-time.Hour))},
+			{JobExecutionId: "jes-2", LastChangeTime: timestamppb.New(now.Add(-2 * time.Hour))},
+		},
+	}
+	exp, err := New(sources, Options{ProgressStallAfter: 90 * time.Minute}, testConfigs("job-progress")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress, _ := exp.collectors[0].Collector.(*jobProgressCollector)
+	progress.now = func() time.Time { return now }
+	exp.Update(context.Background())
+
+	expected := `
+# HELP veidemann_job_progress_last_change_timestamp_seconds Last time the uris crawled, documents crawled or bytes crawled of the latest execution of a job changed, in seconds since the Unix epoch.
+# TYPE veidemann_job_progress_last_change_timestamp_seconds gauge
+veidemann_job_progress_last_change_timestamp_seconds{job_name="daily"} 1.7093016e+09
+veidemann_job_progress_last_change_timestamp_seconds{job_name="monthly"} 1.709298e+09
+veidemann_job_progress_last_change_timestamp_seconds{job_name="weekly"} 1.709298e+09
+# HELP veidemann_job_progress_stalled Whether the latest execution of a job is running without progress for longer than the stall duration.
+# TYPE veidemann_job_progress_stalled gauge
+veidemann_job_progress_stalled{job_name="daily"} 0
+veidemann_job_progress_stalled{job_name="monthly"} 0
+veidemann_job_progress_stalled{job_name="weekly"} 1
+`
+	if err := testutil.CollectAndCompare(exp, strings.NewReader(expected),
+		"veidemann_job_progress_last_change_timestamp_seconds", "veidemann_job_progress_stalled"); err != nil {
+		t.Error(err)
+	}
+
+	// An hour later weekly has progressed while daily has not
+	now = now.Add(time.Hour)
+	jobs[1] = &frontierV1.JobExecutionStatus{Id: "jes-2", JobId: "weekly", State: frontierV1.JobExecutionStatus_RUNNING, StartTime: timestamppb.New(start), UrisCrawled: 20}
+	exp.Update(context.Background())
+
+	expected = `
+# HELP veidemann_job_progress_stalled Whether the latest execution of a job is running without progress for longer than the stall duration.
+# TYPE veidemann_job_progress_stalled gauge
+veidemann_job_progress_stalled{job_name="daily"} 1
+veidemann_job_progress_stalled{job_name="monthly"} 0
+veidemann_job_progress_stalled{job_name="weekly"} 0
+`
+	if err := testutil.CollectAndCompare(exp, strings.NewReader(expected), "veidemann_job_progress_stalled"); err != nil {
+		t.Error(err)
+	}
+	if v := progress.progress["jes-2"].lastChange; !v.Equal(now) {
+		t.Errorf("expected weekly to have progressed at %v, got %v", now, v)
+	}
+}
@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	frontierV1 "github.com/nlnwa/veidemann-api/go/frontier/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestJobCollectorLifecycle(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	exp := newTestExporter(t, Sources{JobExecutions: fakeJobExecutionSource{
+		{
+			JobId:        "daily",
+			State:        frontierV1.JobExecutionStatus_RUNNING,
+			DesiredState: frontierV1.JobExecutionStatus_ABORTED_MANUAL,
+			StartTime:    timestamppb.New(start),
+		},
+		{
+			JobId:     "weekly",
+			State:     frontierV1.JobExecutionStatus_FINISHED,
+			StartTime: timestamppb.New(start),
+			EndTime:   timestamppb.New(start.Add(90 * time.Minute)),
+		},
+		{
+			JobId: "new",
+			State: frontierV1.JobExecutionStatus_CREATED,
+		},
+	}}, "jobs")
+	jobs, _ := exp.collectors[0].Collector.(*jobCollector)
+	jobs.now = func() time.Time { return start.Add(time.Hour) }
+	exp.Update(context.Background())
+
+	expected := `
+# HELP veidemann_job_execution_duration_seconds Time the latest execution of a job has been running, or ran if it has ended.
+# TYPE veidemann_job_execution_duration_seconds gauge
+veidemann_job_execution_duration_seconds{job_name="daily"} 3600
+veidemann_job_execution_duration_seconds{job_name="weekly"} 5400
+# HELP veidemann_job_execution_end_timestamp_seconds End time of the latest execution of a job in seconds since the Unix epoch. Not exported until the execution has ended.
+# TYPE veidemann_job_execution_end_timestamp_seconds gauge
+veidemann_job_execution_end_timestamp_seconds{job_name="weekly"} 1.7092998e+09
+# HELP veidemann_job_execution_start_timestamp_seconds Start time of the latest execution of a job in seconds since the Unix epoch.
+# TYPE veidemann_job_execution_start_timestamp_seconds gauge
+veidemann_job_execution_start_timestamp_seconds{job_name="daily"} 1.7092944e+09
+veidemann_job_execution_start_timestamp_seconds{job_name="weekly"} 1.7092944e+09
+`
+	if err := testutil.CollectAndCompare(exp, strings.NewReader(expected),
+		"veidemann_job_execution_duration_seconds",
+		"veidemann_job_execution_end_timestamp_seconds",
+		"veidemann_job_execution_start_timestamp_seconds"); err != nil {
+		t.Error(err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(exp)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := make(map[string][]string)
+	for _, mf := range families {
+		if mf.GetName() != "veidemann_job_execution_state" && mf.GetName() != "veidemann_job_execution_desired_state" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			if m.GetGauge().GetValue() != 1 {
+				continue
+			}
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			key := mf.GetName() + "/" + labels["job_name"]
+			current[key] = append(current[key], labels["state"])
+		}
+	}
+	want := map[string][]string{
+		"veidemann_job_execution_state/daily":          {"RUNNING"},
+		"veidemann_job_execution_state/weekly":         {"FINISHED"},
+		"veidemann_job_execution_state/new":            {"CREATED"},
+		"veidemann_job_execution_desired_state/daily":  {"ABORTED_MANUAL"},
+		"veidemann_job_execution_desired_state/weekly": {"UNDEFINED"},
+		"veidemann_job_execution_desired_state/new":    {"UNDEFINED"},
+	}
+	if !reflect.DeepEqual(current, want) {
+		t.Errorf("current states = %v, want %v", current, want)
+	}
+	if n := testutil.CollectAndCount(exp, "veidemann_job_execution_state"); n != 3*len(frontierV1.JobExecutionStatus_State_name) {
+		t.Errorf("expected every state of every job, got %d series", n)
+	}
+}
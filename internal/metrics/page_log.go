@@ -0,0 +1,134 @@
+/*
+ * Copyright 2024 National Library of Norway.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	logV1 "github.com/nlnwa/veidemann-api/go/log/v1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerFollowingCollector("page-log", false, newPageLogCollector)
+}
+
+// pageLogBuckets range from pages without any outlinks or resources to pages
+// with thousands.
+var pageLogBuckets = []float64{0, 1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500}
+
+var pageLogResubscriptionsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(Namespace, "page_log", "resubscriptions_total"),
+	"Number of times following the page log was resumed after stopping. Entries written in between are not observed.",
+	nil, nil,
+)
+
+// pageLogCollector observes the pages written to the page log since the
+// exporter started, following the page log by changefeed.
+type pageLogCollector struct {
+	source PageLogSource
+	state  followState
+
+	outlinks  *prometheus.HistogramVec
+	resources *prometheus.HistogramVec
+	loaded    *prometheus.CounterVec
+	dropped   prometheus.Counter
+}
+
+func newPageLogCollector(sources Sources, _ Options) (Collector, error) {
+	if sources.PageLog == nil {
+		return nil, errors.New("no database configured")
+	}
+	return &pageLogCollector{
+		source: sources.PageLog,
+		outlinks: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:                      Namespace,
+			Subsystem:                      "page",
+			Name:                           "outlinks",
+			Help:                           "Number of outlinks found on pages rendered by the browser.",
+			Buckets:                        pageLogBuckets,
+			NativeHistogramBucketFactor:    nativeHistogramBucketFactor,
+			NativeHistogramMaxBucketNumber: nativeHistogramMaxBucketNumber,
+		}, []string{"job_name"}),
+		resources: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:                      Namespace,
+			Subsystem:                      "page",
+			Name:                           "resources",
+			Help:                           "Number of resources loaded by pages rendered by the browser.",
+			Buckets:                        pageLogBuckets,
+			NativeHistogramBucketFactor:    nativeHistogramBucketFactor,
+			NativeHistogramMaxBucketNumber: nativeHistogramMaxBucketNumber,
+		}, []string{"job_name"}),
+		loaded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "page",
+			Name:      "resources_loaded_total",
+			Help:      "Number of resources loaded by pages rendered by the browser, by whether they were served from the browser cache or fetched.",
+		}, []string{"job_name", "source"}),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "page_log",
+			Name:      "dropped_entries_total",
+			Help:      "Number of page log entries that were not observed because they could not be decoded or their job could not be looked up.",
+		}),
+	}, nil
+}
+
+func (c *pageLogCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.outlinks.Describe(ch)
+	c.resources.Describe(ch)
+	c.loaded.Describe(ch)
+	c.dropped.Describe(ch)
+	ch <- pageLogResubscriptionsDesc
+}
+
+// Follow observes page log entries until ctx is done.
+func (c *pageLogCollector) Follow(ctx context.Context) {
+	c.state.follow(ctx, "page_log", func(ctx context.Context) error {
+		return c.source.FollowPageLog(ctx, c.observe, c.dropped.Inc)
+	})
+}
+
+func (c *pageLogCollector) observe(job string, entry *logV1.PageLog) {
+	c.outlinks.WithLabelValues(job).Observe(float64(len(entry.GetOutlink())))
+	c.resources.WithLabelValues(job).Observe(float64(len(entry.GetResource())))
+
+	var cached, fetched int
+	for _, resource := range entry.GetResource() {
+		if resource.GetFromCache() {
+			cached++
+		} else {
+			fetched++
+		}
+	}
+	c.loaded.WithLabelValues(job, "cache").Add(float64(cached))
+	c.loaded.WithLabelValues(job, "fetched").Add(float64(fetched))
+}
+
+// Update returns the current observations, or an error if the page log is not
+// being followed.
+func (c *pageLogCollector) Update(context.Context) ([]prometheus.Metric, error) {
+	if err := c.state.check(); err != nil {
+		return nil, fmt.Errorf("failed to follow page log: %w", err)
+	}
+	metrics := collectMetrics(c.outlinks, c.resources, c.loaded, c.dropped)
+	metrics = append(metrics, prometheus.MustNewConstMetric(pageLogResubscriptionsDesc, prometheus.CounterValue,
+		float64(c.state.resubscribed())))
+	return metrics, nil
+}
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	logV1 "github.com/nlnwa/veidemann-api/go/log/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakePageLogSource sends its entries to the first follower and then blocks
+// until the follower is done.
+type fakePageLogSource struct {
+	entries []fakePageLogEntry
+	sent    chan struct{}
+}
+
+type fakePageLogEntry struct {
+	job   string
+	entry *logV1.PageLog
+}
+
+func (f *fakePageLogSource) FollowPageLog(ctx context.Context, fn func(string, *logV1.PageLog), dropped func()) error {
+	for _, e := range f.entries {
+		if e.entry == nil {
+			dropped()
+			continue
+		}
+		fn(e.job, e.entry)
+	}
+	close(f.sent)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func testPage(outlinks int, fromCache ...bool) *logV1.PageLog {
+	page := &logV1.PageLog{Outlink: make([]string, outlinks)}
+	for _, cached := range fromCache {
+		page.Resource = append(page.Resource, &logV1.PageLog_Resource{FromCache: cached})
+	}
+	return page
+}
+
+func TestPageLogCollector(t *testing.T) {
+	source := &fakePageLogSource{
+		entries: []fakePageLogEntry{
+			{"daily", testPage(3, false, false, true)},
+			{"daily", testPage(40, false, true, true, true)},
+			{"weekly", testPage(0)},
+			{"", nil},
+		},
+		sent: make(chan struct{}),
+	}
+	exp := followTestExporter(t, Sources{PageLog: source}, source.sent, "page-log")
+
+	expected := `
+# HELP veidemann_page_resources_loaded_total Number of resources loaded by pages rendered by the browser, by whether they were served from the browser cache or fetched.
+# TYPE veidemann_page_resources_loaded_total counter
+veidemann_page_resources_loaded_total{job_name="daily",source="cache"} 4
+veidemann_page_resources_loaded_total{job_name="daily",source="fetched"} 3
+veidemann_page_resources_loaded_total{job_name="weekly",source="cache"} 0
+veidemann_page_resources_loaded_total{job_name="weekly",source="fetched"} 0
+`
+	if err := testutil.CollectAndCompare(exp, strings.NewReader(expected), "veidemann_page_resources_loaded_total"); err != nil {
+		t.Error(err)
+	}
+
+	expected = `
+# HELP veidemann_page_outlinks Number of outlinks found on pages rendered by the browser.
+# TYPE veidemann_page_outlinks histogram
+veidemann_page_outlinks_bucket{job_name="daily",le="0"} 0
+veidemann_page_outlinks_bucket{job_name="daily",le="1"} 0
+veidemann_page_outlinks_bucket{job_name="daily",le="5"} 1
+veidemann_page_outlinks_bucket{job_name="daily",le="10"} 1
+veidemann_page_outlinks_bucket{job_name="daily",le="25"} 1
+veidemann_page_outlinks_bucket{job_name="daily",le="50"} 2
+veidemann_page_outlinks_bucket{job_name="daily",le="100"} 2
+veidemann_page_outlinks_bucket{job_name="daily",le="250"} 2
+veidemann_page_outlinks_bucket{job_name="daily",le="500"} 2
+veidemann_page_outlinks_bucket{job_name="daily",le="1000"} 2
+veidemann_page_outlinks_bucket{job_name="daily",le="2500"} 2
+veidemann_page_outlinks_bucket{job_name="daily",le="+Inf"} 2
+veidemann_page_outlinks_sum{job_name="daily"} 43
+veidemann_page_outlinks_count{job_name="daily"} 2
+veidemann_page_outlinks_bucket{job_name="weekly",le="0"} 1
+veidemann_page_outlinks_bucket{job_name="weekly",le="1"} 1
+veidemann_page_outlinks_bucket{job_name="weekly",le="5"} 1
+veidemann_page_outlinks_bucket{job_name="weekly",le="10"} 1
+veidemann_page_outlinks_bucket{job_name="weekly",le="25"} 1
+veidemann_page_outlinks_bucket{job_name="weekly",le="50"} 1
+veidemann_page_outlinks_bucket{job_name="weekly",le="100"} 1
+veidemann_page_outlinks_bucket{job_name="weekly",le="250"} 1
+veidemann_page_outlinks_bucket{job_name="weekly",le="500"} 1
+veidemann_page_outlinks_bucket{job_name="weekly",le="1000"} 1
+veidemann_page_outlinks_bucket{job_name="weekly",le="2500"} 1
+veidemann_page_outlinks_bucket{job_name="weekly",le="+Inf"} 1
+veidemann_page_outlinks_sum{job_name="weekly"} 0
+veidemann_page_outlinks_count{job_name="weekly"} 1
+`
+	if err := testutil.CollectAndCompare(exp, strings.NewReader(expected), "veidemann_page_outlinks"); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(exp, "veidemann_page_resources"); n != 2 {
+		t.Errorf("expected resource histograms of two jobs, got %d", n)
+	}
+
+	expected = `
+# HELP veidemann_page_log_dropped_entries_total Number of page log entries that were not observed because they could not be decoded or their job could not be looked up.
+# TYPE veidemann_page_log_dropped_entries_total counter
+veidemann_page_log_dropped_entries_total 1
+# HELP veidemann_page_log_resubscriptions_total Number of times following the page log was resumed after stopping. Entries written in between are not observed.
+# TYPE veidemann_page_log_resubscriptions_total counter
+veidemann_page_log_resubscriptions_total 0
+`
+	if err := testutil.CollectAndCompare(exp, strings.NewReader(expected),
+		"veidemann_page_log_dropped_entries_total", "veidemann_page_log_resubscriptions_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPageLogCollectorNotFollowing(t *testing.T) {
+	exp := newTestExporter(t, Sources{PageLog: &fakePageLogSource{sent: make(chan struct{})}}, "page-log")
+	exp.Update(context.Background())
+
+	if v := testutil.ToFloat64(exp.errors.WithLabelValues("page-log", errorClassOther)); v != 1 {
+		t.Errorf("expected an error when the page log is not followed, got %v", v)
+	}
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright 2024 National Library of Norway.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// scrapeTimeoutOffset is subtracted from the scrape timeout announced by
+// Prometheus to leave time for encoding and transferring the response.
+const scrapeTimeoutOffset = 500 * time.Millisecond
+
+// ScrapeContext returns a context bounded by the scrape timeout that
+// Prometheus sends in the X-Prometheus-Scrape-Timeout-Seconds header. Without
+// the header the context is only bounded by the request.
+func ScrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	seconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || seconds <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > scrapeTimeoutOffset {
+		timeout -= scrapeTimeoutOffset
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+// ScrapeHandler wraps a metrics handler so that every request first updates
+// the collectors running in scrape mode. Collectors running in background mode
+// are served from their latest update as usual.
+func (e *Exporter) ScrapeHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := ScrapeContext(r)
+		e.updateMatching(ctx, func(c *namedCollector) bool { return c.Mode == ModeScrape })
+		cancel()
+		next.ServeHTTP(w, r)
+	})
+}
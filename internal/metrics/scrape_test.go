@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestScrapeContext(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"invalid", 0},
+		{"10", 9500 * time.Millisecond},
+		{"0.2", 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if tt.header != "" {
+			r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", tt.header)
+		}
+		ctx, cancel := ScrapeContext(r)
+		deadline, ok := ctx.Deadline()
+		cancel()
+		if tt.want == 0 {
+			if ok {
+				t.Errorf("header %q: expected no deadline", tt.header)
+			}
+			continue
+		}
+		if got := time.Until(deadline); !ok || got > tt.want || got < tt.want-time.Second {
+			t.Errorf("header %q: deadline in %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestScrapeHandler(t *testing.T) {
+	source := &failingQueueSource{count: 1}
+	exp, err := New(Sources{JobExecutions: fakeJobExecutionSource{}, Frontier: source}, Options{},
+		CollectorConfig{Name: "jobs", Interval: time.Minute, Timeout: time.Second},
+		CollectorConfig{Name: "uri-queue", Mode: ModeScrape, Timeout: time.Second},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(exp)
+	handler := exp.ScrapeHandler(promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return rec.Body.String()
+	}
+
+	if body := scrape(); !strings.Contains(body, "veidemann_uri_queue_count 1\n") {
+		t.Errorf("expected queue count from scrape, got:\n%s", body)
+	}
+	source.count = 2
+	if body := scrape(); !strings.Contains(body, "veidemann_uri_queue_count 2\n") {
+		t.Errorf("expected queue count updated by scrape, got:\n%s", body)
+	}
+	if body := scrape(); !strings.Contains(body, `veidemann_exporter_collector_success{collector="jobs"} 0`) {
+		t.Errorf("expected background collector not to be updated by scrape, got:\n%s", body)
+	}
+}
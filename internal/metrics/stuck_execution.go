@@ -0,0 +1,158 @@
+/*
+ * Copyright 2024 National Library of Norway.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	frontierV1 "github.com/nlnwa/veidemann-api/go/frontier/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	stuckCrawlExecutionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "stuck", "crawl_executions"),
+		"Number of fetching or sleeping crawl executions of a job that have not changed within the stuck threshold.",
+		[]string{"job_name"}, nil,
+	)
+
+	stuckJobExecutionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "stuck", "job_executions"),
+		"Number of running executions of a job whose crawl executions have all ended.",
+		[]string{"job_name"}, nil,
+	)
+
+	stuckCrawlExecutionDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "stuck", "crawl_execution_last_change_timestamp_seconds"),
+		"Last change time of a stuck crawl execution in seconds since the Unix epoch.",
+		[]string{"job_name", "execution_id", "crawl_execution_id", "seed_id", "state"}, nil,
+	)
+
+	stuckJobExecutionDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "stuck", "job_execution_info"),
+		"A running job execution whose crawl executions have all ended.",
+		[]string{"job_name", "execution_id"}, nil,
+	)
+)
+
+func init() {
+	registerCollector("stuck-executions", false, newStuckExecutionCollector)
+}
+
+// stuckExecutionCollector detects crawl executions that have stopped making
+// progress and job executions that should have ended.
+type stuckExecutionCollector struct {
+	jobs   JobExecutionSource
+	source CrawlExecutionSource
+	// threshold is how long a fetching or sleeping crawl execution may go
+	// without changing before it is considered stuck.
+	threshold time.Duration
+	now       func() time.Time
+}
+
+func newStuckExecutionCollector(sources Sources, options Options) (Collector, error) {
+	if sources.JobExecutions == nil || sources.CrawlExecutions == nil {
+		return nil, errors.New("no database configured")
+	}
+	if options.StuckThreshold <= 0 {
+		return nil, errors.New("threshold must be positive")
+	}
+	return &stuckExecutionCollector{
+		jobs:      sources.JobExecutions,
+		source:    sources.CrawlExecutions,
+		threshold: options.StuckThreshold,
+		now:       time.Now,
+	}, nil
+}
+
+func (c *stuckExecutionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- stuckCrawlExecutionsDesc
+	ch <- stuckJobExecutionsDesc
+	ch <- stuckCrawlExecutionDesc
+	ch <- stuckJobExecutionDesc
+}
+
+// Update returns the number of stuck crawl and job executions of every job with
+// a running job execution, and a series for each stuck execution.
+func (c *stuckExecutionCollector) Update(ctx context.Context) ([]prometheus.Metric, error) {
+	var running []*frontierV1.JobExecutionStatus
+	err := c.jobs.WalkRunningJobExecutions(ctx, func(jes *frontierV1.JobExecutionStatus) {
+		running = append(running, jes)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query running job executions: %w", err)
+	}
+
+	var metrics []prometheus.Metric
+	// stuck maps job names to the number of stuck crawl and job executions
+	stuck := make(map[string]*[2]int)
+	deadline := c.now().Add(-c.threshold)
+	for _, jes := range running {
+		name := jes.GetJobId()
+		if stuck[name] == nil {
+			stuck[name] = new([2]int)
+		}
+
+		total, ended := 0, 0
+		err := c.source.WalkCrawlExecutions(ctx, jes.GetId(), func(ces *frontierV1.CrawlExecutionStatus) {
+			total++
+			if isCrawlExecutionEnded(ces) {
+				ended++
+				return
+			}
+			if !isCrawlExecutionStuck(ces, deadline) {
+				return
+			}
+			stuck[name][0]++
+			log.Debug().Str("job", name).Str("jobExecutionId", jes.GetId()).Str("crawlExecutionId", ces.GetId()).
+				Str("seedId", ces.GetSeedId()).Time("lastChangeTime", ces.GetLastChangeTime().AsTime()).Msg("Crawl execution is stuck")
+			metrics = append(metrics, prometheus.MustNewConstMetric(stuckCrawlExecutionDesc, prometheus.GaugeValue,
+				timestampSeconds(ces.GetLastChangeTime().AsTime()), name, jes.GetId(), ces.GetId(), ces.GetSeedId(), ces.GetState().String()))
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query crawl executions of job execution %s: %w", jes.GetId(), err)
+		}
+
+		if jes.GetState() == frontierV1.JobExecutionStatus_RUNNING && total > 0 && ended == total {
+			stuck[name][1]++
+			log.Debug().Str("job", name).Str("jobExecutionId", jes.GetId()).Msg("Job execution is running with all crawl executions ended")
+			metrics = append(metrics, prometheus.MustNewConstMetric(stuckJobExecutionDesc, prometheus.GaugeValue, 1, name, jes.GetId()))
+		}
+	}
+	for name, n := range stuck {
+		metrics = append(metrics,
+			prometheus.MustNewConstMetric(stuckCrawlExecutionsDesc, prometheus.GaugeValue, float64(n[0]), name),
+			prometheus.MustNewConstMetric(stuckJobExecutionsDesc, prometheus.GaugeValue, float64(n[1]), name),
+		)
+	}
+	return metrics, nil
+}
+
+// isCrawlExecutionStuck reports whether a crawl execution is fetching or
+// sleeping and has not changed since deadline.
+func isCrawlExecutionStuck(ces *frontierV1.CrawlExecutionStatus, deadline time.Time) bool {
+	switch ces.GetState() {
+	case frontierV1.CrawlExecutionStatus_FETCHING, frontierV1.CrawlExecutionStatus_SLEEPING:
+		return ces.GetLastChangeTime() != nil && ces.GetLastChangeTime().AsTime().Before(deadline)
+	default:
+		return false
+	}
+}
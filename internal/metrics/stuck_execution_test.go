@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	frontierV1 "github.com/nlnwa/veidemann-api/go/frontier/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestStuckExecutionCollector(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	crawlExecution := func(id, jobExecutionId string, state frontierV1.CrawlExecutionStatus_State, idle time.Duration) *frontierV1.CrawlExecutionStatus {
+		return &frontierV1.CrawlExecutionStatus{
+			Id:             id,
+			JobExecutionId: jobExecutionId,
+			SeedId:         "seed-" + id,
+			State:          state,
+			LastChangeTime: timestamppb.New(now.Add(-idle)),
+		}
+	}
+	sources := Sources{
+		JobExecutions: fakeJobExecutionSource{
+			{Id: "jes-1", JobId: "daily", State: frontierV1.JobExecutionStatus_RUNNING},
+			{Id: "jes-2", JobId: "weekly", State: frontierV1.JobExecutionStatus_RUNNING},
+			{Id: "jes-3", JobId: "monthly", State: frontierV1.JobExecutionStatus_CREATED},
+		},
+		CrawlExecutions: fakeCrawlExecutionSource{
+			crawlExecution("1", "jes-1", frontierV1.CrawlExecutionStatus_FETCHING, 2*time.Hour),
+			crawlExecution("2", "jes-1", frontierV1.CrawlExecutionStatus_SLEEPING, time.Minute),
+			crawlExecution("3", "jes-1", frontierV1.CrawlExecutionStatus_CREATED, 3*time.Hour),
+			crawlExecution("4", "jes-2", frontierV1.CrawlExecutionStatus_FINISHED, 3*time.Hour),
+			crawlExecution("5", "jes-2", frontierV1.CrawlExecutionStatus_DIED, 3*time.Hour),
+			crawlExecution("6", "jes-3", frontierV1.CrawlExecutionStatus_FINISHED, 3*time.Hour),
+		},
+	}
+	exp, err := New(sources, Options{StuckThreshold: time.Hour}, testConfigs("stuck-executions")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stuck, _ := exp.collectors[0].Collector.(*stuckExecutionCollector)
+	stuck.now = func() time.Time { return now }
+	exp.Update(context.Background())
+
+	expected := `
+# HELP veidemann_stuck_crawl_execution_last_change_timestamp_seconds Last change time of a stuck crawl execution in seconds since the Unix epoch.
+# TYPE veidemann_stuck_crawl_execution_last_change_timestamp_seconds gauge
+veidemann_stuck_crawl_execution_last_change_timestamp_seconds{crawl_execution_id="1",execution_id="jes-1",job_name="daily",seed_id="seed-1",state="FETCHING"} 1.7092872e+09
+# HELP veidemann_stuck_crawl_executions Number of fetching or sleeping crawl executions of a job that have not changed within the stuck threshold.
+# TYPE veidemann_stuck_crawl_executions gauge
+veidemann_stuck_crawl_executions{job_name="daily"} 1
+veidemann_stuck_crawl_executions{job_name="monthly"} 0
+veidemann_stuck_crawl_executions{job_name="weekly"} 0
+# HELP veidemann_stuck_job_execution_info A running job execution whose crawl executions have all ended.
+# TYPE veidemann_stuck_job_execution_info gauge
+veidemann_stuck_job_execution_info{execution_id="jes-2",job_name="weekly"} 1
+# HELP veidemann_stuck_job_executions Number of running executions of a job whose crawl executions have all ended.
+# TYPE veidemann_stuck_job_executions gauge
+veidemann_stuck_job_executions{job_name="daily"} 0
+veidemann_stuck_job_executions{job_name="monthly"} 0
+veidemann_stuck_job_executions{job_name="weekly"} 1
+`
+	if err := testutil.CollectAndCompare(exp, strings.NewReader(expected),
+		"veidemann_stuck_crawl_execution_last_change_timestamp_seconds",
+		"veidemann_stuck_crawl_executions",
+		"veidemann_stuck_job_execution_info",
+		"veidemann_stuck_job_executions"); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := New(sources, Options{}, testConfigs("stuck-executions")...); err == nil {
+		t.Error("expected error without a threshold")
+	}
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright 2018 National Library of Norway.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var uriQueueCountDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(Namespace, "uri", "queue_count"),
+	"Number of uris in queue.",
+	nil, nil,
+)
+
+func init() {
+	registerCollector("uri-queue", true, newUriQueueCollector)
+}
+
+// uriQueueCollector collects the total number of queued uris from the frontier.
+type uriQueueCollector struct {
+	source QueueSource
+}
+
+func newUriQueueCollector(sources Sources, _ Options) (Collector, error) {
+	if sources.Frontier == nil {
+		return nil, errors.New("no frontier configured")
+	}
+	return &uriQueueCollector{source: sources.Frontier}, nil
+}
+
+func (c *uriQueueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- uriQueueCountDesc
+}
+
+func (c *uriQueueCollector) Update(ctx context.Context) ([]prometheus.Metric, error) {
+	count, err := c.source.QueueCountTotal(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get queue count from frontier: %w", err)
+	}
+	return []prometheus.Metric{
+		prometheus.MustNewConstMetric(uriQueueCountDesc, prometheus.GaugeValue, float64(count)),
+	}, nil
+}
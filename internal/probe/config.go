@@ -0,0 +1,107 @@
+/*
+ * Copyright 2024 National Library of Norway.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package probe
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// DBConfig holds the RethinkDB connection settings of a target.
+type DBConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Name     string `mapstructure:"name"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+// FrontierConfig holds the frontier connection settings of a target.
+type FrontierConfig struct {
+	Host string `mapstructure:"host"`
+	Port int    `mapstructure:"port"`
+}
+
+// TargetConfig describes a Veidemann installation that can be probed.
+type TargetConfig struct {
+	Name     string         `mapstructure:"name"`
+	DB       DBConfig       `mapstructure:"db"`
+	Frontier FrontierConfig `mapstructure:"frontier"`
+}
+
+// LoadConfig reads the list of targets from a config file.
+//
+// Example:
+//
+//	targets:
+//	  - name: production
+//	    db:
+//	      host: rethinkdb-proxy.prod
+//	      password: secret
+//	    frontier:
+//	      host: veidemann-frontier.prod
+func LoadConfig(path string) ([]TargetConfig, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read targets file: %w", err)
+	}
+	var cfg struct {
+		Targets []TargetConfig `mapstructure:"targets"`
+	}
+	if err := v.Unmarshal(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse targets file: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for i := range cfg.Targets {
+		t := &cfg.Targets[i]
+		if t.Name == "" {
+			return nil, fmt.Errorf("target %d has no name", i)
+		}
+		if seen[t.Name] {
+			return nil, fmt.Errorf("duplicate target: %s", t.Name)
+		}
+		seen[t.Name] = true
+		if t.DB.Host == "" {
+			return nil, fmt.Errorf("target %s has no database host", t.Name)
+		}
+		if t.Frontier.Host == "" {
+			return nil, fmt.Errorf("target %s has no frontier host", t.Name)
+		}
+		setDefaults(t)
+	}
+	return cfg.Targets, nil
+}
+
+// setDefaults fills in the settings a target may leave out with the same
+// defaults as the command line flags.
+func setDefaults(t *TargetConfig) {
+	if t.DB.Port == 0 {
+		t.DB.Port = 28015
+	}
+	if t.DB.Name == "" {
+		t.DB.Name = "veidemann"
+	}
+	if t.DB.Username == "" {
+		t.DB.Username = "admin"
+	}
+	if t.Frontier.Port == 0 {
+		t.Frontier.Port = 7700
+	}
+}
@@ -0,0 +1,75 @@
+package probe
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets.yaml")
+	content := `
+targets:
+  - name: Production
+    db:
+      host: rethinkdb-proxy.prod
+      password: secret
+    frontier:
+      host: veidemann-frontier.prod
+  - name: test
+    db:
+      host: rethinkdb-proxy.test
+      port: 28016
+      name: veidemann
+      username: exporter
+    frontier:
+      host: veidemann-frontier.test
+      port: 7701
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TargetConfig{
+		{
+			Name:     "Production",
+			DB:       DBConfig{Host: "rethinkdb-proxy.prod", Port: 28015, Name: "veidemann", Username: "admin", Password: "secret"},
+			Frontier: FrontierConfig{Host: "veidemann-frontier.prod", Port: 7700},
+		},
+		{
+			Name:     "test",
+			DB:       DBConfig{Host: "rethinkdb-proxy.test", Port: 28016, Name: "veidemann", Username: "exporter"},
+			Frontier: FrontierConfig{Host: "veidemann-frontier.test", Port: 7701},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadConfig() = %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"missing name", "targets:\n  - db: {host: db}\n    frontier: {host: frontier}\n"},
+		{"duplicate name", "targets:\n  - {name: a, db: {host: db}, frontier: {host: frontier}}\n  - {name: a, db: {host: db}, frontier: {host: frontier}}\n"},
+		{"missing db host", "targets:\n  - {name: a, frontier: {host: frontier}}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "targets.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadConfig(path); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+/*
+ * Copyright 2024 National Library of Norway.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package probe serves metrics of several Veidemann installations from one exporter.
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nlnwa/veidemann-metrics/internal/frontier"
+	"github.com/nlnwa/veidemann-metrics/internal/metrics"
+	"github.com/nlnwa/veidemann-metrics/internal/rethinkdb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// target is a probed installation and its connections, which are established
+// on first probe and reused by later probes.
+type target struct {
+	TargetConfig
+	cache *metrics.Cache
+
+	mu   sync.Mutex
+	db   *rethinkdb.Query
+	conn *grpc.ClientConn
+	exp  *metrics.Exporter
+}
+
+// sources returns the data sources of the target, connecting if necessary.
+func (t *target) sources() (metrics.Sources, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.db == nil {
+		db := rethinkdb.NewConnection(t.DB.Host, t.DB.Port, t.DB.Username, t.DB.Password, t.DB.Name, 1*time.Minute)
+		if err := db.Connect(); err != nil {
+			return metrics.Sources{}, fmt.Errorf("failed to connect to RethinkDB: %w", err)
+		}
+		if err := db.Verify(); err != nil {
+			_ = db.Close()
+			return metrics.Sources{}, fmt.Errorf("database is not initialized: %w", err)
+		}
+		t.db = db
+	}
+	if t.conn == nil {
+		address := fmt.Sprintf("%s:%d", t.Frontier.Host, t.Frontier.Port)
+		conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return metrics.Sources{}, fmt.Errorf("failed to create frontier client: %w", err)
+		}
+		t.conn = conn
+	}
+	return metrics.Sources{
+		JobExecutions:   t.db,
+		JobHistory:      t.db,
+		CrawlExecutions: t.db,
+		Frontier:        frontier.New(t.conn),
+	}, nil
+}
+
+// exporter returns the exporter of the target, creating it on first use, so
+// that collectors accumulating observations keep them between probes.
+func (t *target) exporter(sources metrics.Sources, options metrics.Options, collectors []metrics.CollectorConfig) (*metrics.Exporter, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.exp == nil {
+		exp, err := metrics.New(sources, options, collectors...)
+		if err != nil {
+			return nil, err
+		}
+		exp.SetCache(t.cache)
+		t.exp = exp
+	}
+	return t.exp, nil
+}
+
+func (t *target) close() error {
+	t.cache.Close()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.exp = nil
+	var errs []error
+	if t.conn != nil {
+		errs = append(errs, t.conn.Close())
+		t.conn = nil
+	}
+	if t.db != nil {
+		errs = append(errs, t.db.Close())
+		t.db = nil
+	}
+	return errors.Join(errs...)
+}
+
+// Handler serves the metrics of a target given by the target query parameter,
+// updated on every request by an exporter kept for the target. Probes of the
+// same target share cached collector results.
+type Handler struct {
+	targets    map[string]*target
+	options    metrics.Options
+	collectors []metrics.CollectorConfig
+}
+
+// NewHandler creates a Handler probing the given targets with the given
+// collectors. Collectors that follow their data source are left out, since
+// the exporters of targets are only updated by probes and never run. Queries
+// shared by probes are canceled when ctx is done.
+func NewHandler(ctx context.Context, targets []TargetConfig, options metrics.Options, collectors []metrics.CollectorConfig) *Handler {
+	h := &Handler{
+		targets: make(map[string]*target, len(targets)),
+		options: options,
+	}
+	for _, c := range collectors {
+		if metrics.Follows(c.Name) {
+			log.Info().Str("collector", c.Name).Msg("Collector is not available by probe")
+			continue
+		}
+		h.collectors = append(h.collectors, c)
+	}
+	for _, t := range targets {
+		h.targets[t.Name] = &target{TargetConfig: t, cache: metrics.NewCache(ctx)}
+	}
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("target")
+	if name == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	t, ok := h.targets[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown target: %s", name), http.StatusNotFound)
+		return
+	}
+
+	registry := prometheus.NewRegistry()
+	sources, err := t.sources()
+	if err != nil {
+		log.Error().Err(err).Str("target", name).Msg("Failed to connect to target")
+		registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Name:      "up",
+			Help:      "Whether the latest update of every collector succeeded.",
+		}, func() float64 { return 0 }))
+	} else {
+		exp, err := t.exporter(sources, h.options, h.collectors)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ctx, cancel := metrics.ScrapeContext(r)
+		exp.Update(ctx)
+		cancel()
+		registry.MustRegister(exp)
+	}
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// Close waits for the queries of all targets to return and closes their
+// connections.
+func (h *Handler) Close() error {
+	var errs []error
+	for _, t := range h.targets {
+		errs = append(errs, t.close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,37 @@
+package probe
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nlnwa/veidemann-metrics/internal/metrics"
+)
+
+func TestHandlerRejectsUnknownTarget(t *testing.T) {
+	h := NewHandler(context.Background(), []TargetConfig{{Name: "production"}}, metrics.Options{}, nil)
+
+	tests := []struct {
+		url  string
+		want int
+	}{
+		{"/probe", http.StatusBadRequest},
+		{"/probe?target=staging", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+		if rec.Code != tt.want {
+			t.Errorf("GET %s = %d, want %d", tt.url, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestHandlerLeavesOutFollowingCollectors(t *testing.T) {
+	h := NewHandler(context.Background(), nil, metrics.Options{}, []metrics.CollectorConfig{{Name: "jobs"}, {Name: "crawl-log"}})
+
+	if len(h.collectors) != 1 || h.collectors[0].Name != "jobs" {
+		t.Errorf("expected only the jobs collector, got %v", h.collectors)
+	}
+}
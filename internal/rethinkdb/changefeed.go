@@ -0,0 +1,264 @@
+/*
+ * Copyright 2024 National Library of Norway.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rethinkdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/nlnwa/veidemann-api/go/frontier/v1"
+	"github.com/nlnwa/veidemann-metrics/internal/follow"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/proto"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+// ErrNotReady is returned when the initial state of a changefeed is not loaded yet.
+var ErrNotReady = errors.New("changefeed is not ready")
+
+// change is a changefeed document. With include_states the feed also emits
+// documents carrying only a state, which is "ready" when the initial values
+// have been sent.
+type change struct {
+	NewVal map[string]interface{} `rethinkdb:"new_val"`
+	OldVal map[string]interface{} `rethinkdb:"old_val"`
+	State  string                 `rethinkdb:"state"`
+}
+
+// crawlJob is the part of a crawlJob config needed to name job executions.
+type crawlJob struct {
+	Id   string `rethinkdb:"id"`
+	Meta struct {
+		Name string `rethinkdb:"name"`
+	} `rethinkdb:"meta"`
+}
+
+type crawlJobChange struct {
+	NewVal *crawlJob `rethinkdb:"new_val"`
+	OldVal *crawlJob `rethinkdb:"old_val"`
+	State  string    `rethinkdb:"state"`
+}
+
+// JobExecutionWatcher keeps job executions in memory, maintained by
+// changefeeds on the job_executions and config tables. It serves the same data
+// as Query.WalkLatestJobExecutionForCrawlJobs and Query.WalkRunningJobExecutions
+// without querying the database on every collection.
+//
+// Only the latest execution of each job and the executions that have not
+// terminated are kept, so if the latest execution is deleted the job has no
+// execution until a new one is started.
+type JobExecutionWatcher struct {
+	query *Query
+
+	mu sync.RWMutex
+	// names maps crawl job ids to crawl job names.
+	names map[string]string
+	// latest maps crawl job ids to their latest job execution.
+	latest map[string]*frontier.JobExecutionStatus
+	// running maps ids of job executions that have not terminated to the execution.
+	running    map[string]*frontier.JobExecutionStatus
+	namesReady bool
+	jobsReady  bool
+}
+
+// NewJobExecutionWatcher creates a JobExecutionWatcher reading from the
+// database of the given query. It serves no data until Run is called.
+func NewJobExecutionWatcher(query *Query) *JobExecutionWatcher {
+	return &JobExecutionWatcher{
+		query:   query,
+		names:   make(map[string]string),
+		latest:  make(map[string]*frontier.JobExecutionStatus),
+		running: make(map[string]*frontier.JobExecutionStatus),
+	}
+}
+
+// Run follows the changefeeds until ctx is done, resubscribing with backoff
+// when a feed fails.
+func (w *JobExecutionWatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		follow.Run(ctx, "config", w.followCrawlJobs)
+	}()
+	go func() {
+		defer wg.Done()
+		follow.Run(ctx, "job_executions", w.followJobExecutions)
+	}()
+	wg.Wait()
+}
+
+func (w *JobExecutionWatcher) followCrawlJobs(ctx context.Context) error {
+	cursor, err := r.Table("config").
+		Filter(map[string]interface{}{"kind": "crawlJob"}).
+		Pluck("id", map[string]interface{}{"meta": "name"}).
+		Changes(r.ChangesOpts{IncludeInitial: true, IncludeStates: true}).
+		Run(w.query.session, r.RunOpts{Context: ctx})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to crawl jobs: %w", err)
+	}
+	defer func() { _ = cursor.Close() }()
+
+	w.mu.Lock()
+	w.names = make(map[string]string)
+	w.namesReady = false
+	w.mu.Unlock()
+
+	var c crawlJobChange
+	for cursor.Next(&c) {
+		w.applyCrawlJob(c)
+		c = crawlJobChange{}
+	}
+	return cursor.Err()
+}
+
+func (w *JobExecutionWatcher) followJobExecutions(ctx context.Context) error {
+	cursor, err := r.Table("job_executions").
+		Map(normalizeJobExecution).
+		Changes(r.ChangesOpts{IncludeInitial: true, IncludeStates: true}).
+		Run(w.query.session, r.RunOpts{Context: ctx})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to job executions: %w", err)
+	}
+	defer func() { _ = cursor.Close() }()
+
+	w.mu.Lock()
+	w.latest = make(map[string]*frontier.JobExecutionStatus)
+	w.running = make(map[string]*frontier.JobExecutionStatus)
+	w.jobsReady = false
+	w.mu.Unlock()
+
+	var c change
+	for cursor.Next(&c) {
+		if err := w.applyJobExecution(c); err != nil {
+			log.Warn().Err(err).Msg("Skipping undecodable job execution")
+		}
+		c = change{}
+	}
+	return cursor.Err()
+}
+
+func (w *JobExecutionWatcher) applyCrawlJob(c crawlJobChange) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if c.State == "ready" {
+		w.namesReady = true
+	}
+	if c.OldVal != nil {
+		delete(w.names, c.OldVal.Id)
+	}
+	if c.NewVal != nil {
+		w.names[c.NewVal.Id] = c.NewVal.Meta.Name
+	}
+}
+
+func (w *JobExecutionWatcher) applyJobExecution(c change) error {
+	var oldVal, newVal *frontier.JobExecutionStatus
+	if c.OldVal != nil {
+		oldVal = new(frontier.JobExecutionStatus)
+		if err := unmarshalProto(c.OldVal, oldVal); err != nil {
+			return fmt.Errorf("failed to decode old job execution: %w", err)
+		}
+	}
+	if c.NewVal != nil {
+		newVal = new(frontier.JobExecutionStatus)
+		if err := unmarshalProto(c.NewVal, newVal); err != nil {
+			return fmt.Errorf("failed to decode new job execution: %w", err)
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if c.State == "ready" {
+		w.jobsReady = true
+	}
+	if oldVal != nil {
+		delete(w.running, oldVal.GetId())
+	}
+	if newVal == nil {
+		if oldVal != nil {
+			if cur, ok := w.latest[oldVal.GetJobId()]; ok && cur.GetId() == oldVal.GetId() {
+				delete(w.latest, oldVal.GetJobId())
+			}
+		}
+		return nil
+	}
+	if isRunning(newVal) {
+		w.running[newVal.GetId()] = newVal
+	}
+	if cur, ok := w.latest[newVal.GetJobId()]; !ok || isSameOrLater(newVal, cur) {
+		w.latest[newVal.GetJobId()] = newVal
+	}
+	return nil
+}
+
+// isSameOrLater reports whether a is the same execution as b or started after
+// it. An execution without a start time has not started yet and is the latest.
+func isSameOrLater(a, b *frontier.JobExecutionStatus) bool {
+	if a.GetId() == b.GetId() || a.GetStartTime() == nil {
+		return true
+	}
+	if b.GetStartTime() == nil {
+		return false
+	}
+	return !a.GetStartTime().AsTime().Before(b.GetStartTime().AsTime())
+}
+
+// WalkLatestJobExecutionForCrawlJobs calls fn with the latest job execution of
+// every crawl job, with the jobId replaced by the name of the crawl job.
+func (w *JobExecutionWatcher) WalkLatestJobExecutionForCrawlJobs(ctx context.Context, fn func(*frontier.JobExecutionStatus)) error {
+	return w.walk(ctx, func() map[string]*frontier.JobExecutionStatus { return w.latest }, fn)
+}
+
+// WalkRunningJobExecutions calls fn with every job execution that has not
+// reached a terminal state, with the jobId replaced by the name of the crawl job.
+func (w *JobExecutionWatcher) WalkRunningJobExecutions(ctx context.Context, fn func(*frontier.JobExecutionStatus)) error {
+	return w.walk(ctx, func() map[string]*frontier.JobExecutionStatus { return w.running }, fn)
+}
+
+// walk calls fn with a named copy of every job execution in the map returned
+// by executions, which is called with the lock held.
+func (w *JobExecutionWatcher) walk(ctx context.Context, executions func() map[string]*frontier.JobExecutionStatus, fn func(*frontier.JobExecutionStatus)) error {
+	w.mu.RLock()
+	if !w.namesReady || !w.jobsReady {
+		w.mu.RUnlock()
+		return ErrNotReady
+	}
+	named := make([]*frontier.JobExecutionStatus, 0, len(executions()))
+	for _, jes := range executions() {
+		name, ok := w.names[jes.GetJobId()]
+		if !ok {
+			continue
+		}
+		c, _ := proto.Clone(jes).(*frontier.JobExecutionStatus)
+		c.JobId = name
+		named = append(named, c)
+	}
+	w.mu.RUnlock()
+
+	for _, jes := range named {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fn(jes)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package rethinkdb
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/nlnwa/veidemann-api/go/frontier/v1"
+)
+
+func jobExecutionDoc(id, jobId string, startTime time.Time, urisCrawled int) map[string]interface{} {
+	doc := map[string]interface{}{
+		"id":              id,
+		"jobId":           jobId,
+		"state":           "RUNNING",
+		"urisCrawled":     urisCrawled,
+		"executionsState": map[string]interface{}{"FETCHING": 1},
+	}
+	if !startTime.IsZero() {
+		doc["startTime"] = startTime
+	}
+	return doc
+}
+
+func walk(t *testing.T, w *JobExecutionWatcher) map[string]*frontier.JobExecutionStatus {
+	t.Helper()
+	got := make(map[string]*frontier.JobExecutionStatus)
+	err := w.WalkLatestJobExecutionForCrawlJobs(context.Background(), func(jes *frontier.JobExecutionStatus) {
+		got[jes.GetJobId()] = jes
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestJobExecutionWatcher(t *testing.T) {
+	w := NewJobExecutionWatcher(nil)
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if err := w.WalkLatestJobExecutionForCrawlJobs(context.Background(), func(*frontier.JobExecutionStatus) {}); !errors.Is(err, ErrNotReady) {
+		t.Fatalf("expected ErrNotReady before initial state, got %v", err)
+	}
+
+	daily := &crawlJob{Id: "job-1"}
+	daily.Meta.Name = "daily"
+	weekly := &crawlJob{Id: "job-2"}
+	weekly.Meta.Name = "weekly"
+	w.applyCrawlJob(crawlJobChange{NewVal: daily})
+	w.applyCrawlJob(crawlJobChange{NewVal: weekly})
+	w.applyCrawlJob(crawlJobChange{State: "ready"})
+
+	for _, c := range []change{
+		{NewVal: jobExecutionDoc("jes-1", "job-1", t0, 1)},
+		{NewVal: jobExecutionDoc("jes-2", "job-1", t0.Add(time.Hour), 2)},
+		// An older execution arriving late does not replace the latest
+		{NewVal: jobExecutionDoc("jes-0", "job-1", t0.Add(-time.Hour), 3)},
+		{NewVal: jobExecutionDoc("jes-3", "job-2", t0, 4)},
+		// Execution of a crawl job that no longer exists
+		{NewVal: jobExecutionDoc("jes-4", "job-3", t0, 5)},
+		{State: "ready"},
+	} {
+		if err := w.applyJobExecution(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := walk(t, w)
+	var names []string
+	for name := range got {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "daily" || names[1] != "weekly" {
+		t.Fatalf("expected executions of daily and weekly, got %v", names)
+	}
+	if id := got["daily"].GetId(); id != "jes-2" {
+		t.Errorf("expected latest execution jes-2 of daily, got %s", id)
+	}
+	if n := got["daily"].GetExecutionsState()["FETCHING"]; n != 1 {
+		t.Errorf("expected executions state to be decoded, got %v", got["daily"].GetExecutionsState())
+	}
+
+	running := 0
+	if err := w.WalkRunningJobExecutions(context.Background(), func(*frontier.JobExecutionStatus) { running++ }); err != nil {
+		t.Fatal(err)
+	}
+	if running != 4 {
+		t.Errorf("expected four running executions of existing jobs, got %d", running)
+	}
+
+	// Update of the latest execution
+	if err := w.applyJobExecution(change{OldVal: jobExecutionDoc("jes-2", "job-1", t0.Add(time.Hour), 2), NewVal: jobExecutionDoc("jes-2", "job-1", t0.Add(time.Hour), 20)}); err != nil {
+		t.Fatal(err)
+	}
+	// Rename of weekly
+	renamed := &crawlJob{Id: "job-2"}
+	renamed.Meta.Name = "monthly"
+	w.applyCrawlJob(crawlJobChange{OldVal: weekly, NewVal: renamed})
+	// Deletion of daily
+	w.applyCrawlJob(crawlJobChange{OldVal: daily})
+
+	got = walk(t, w)
+	if _, ok := got["daily"]; ok {
+		t.Error("expected deleted job to be gone")
+	}
+	if got["monthly"].GetUrisCrawled() != 4 {
+		t.Errorf("expected renamed job to keep its execution, got %v", got)
+	}
+
+	// A new execution without start time is the latest
+	w.applyCrawlJob(crawlJobChange{NewVal: daily})
+	if err := w.applyJobExecution(change{NewVal: jobExecutionDoc("jes-5", "job-1", time.Time{}, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	if id := walk(t, w)["daily"].GetId(); id != "jes-5" {
+		t.Errorf("expected created execution jes-5 to be the latest, got %s", id)
+	}
+}
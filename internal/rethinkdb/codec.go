@@ -29,14 +29,8 @@ import (
 )
 
 var decodeJobExecutionStatus = func(encoded interface{}, value reflect.Value) error {
-	b, err := json.Marshal(encoded)
-	if err != nil {
-		return fmt.Errorf("failed to marshal encoded value to json: %w", err)
-	}
-
 	var jes frontierV1.JobExecutionStatus
-	err = protojson.UnmarshalOptions{AllowPartial: true}.Unmarshal(b, &jes)
-	if err != nil {
+	if err := unmarshalProto(encoded, &jes); err != nil {
 		return fmt.Errorf("failed to unmarshal json to job execution status: %w", err)
 	}
 
@@ -45,6 +39,26 @@ var decodeJobExecutionStatus = func(encoded interface{}, value reflect.Value) er
 	return nil
 }
 
+var decodeCrawlExecutionStatus = func(encoded interface{}, value reflect.Value) error {
+	var ces frontierV1.CrawlExecutionStatus
+	if err := unmarshalProto(encoded, &ces); err != nil {
+		return fmt.Errorf("failed to unmarshal json to crawl execution status: %w", err)
+	}
+
+	value.Set(reflect.ValueOf(&ces).Elem())
+
+	return nil
+}
+
+// unmarshalProto converts a document decoded by the driver to a proto message.
+func unmarshalProto(encoded interface{}, m proto.Message) error {
+	b, err := json.Marshal(encoded)
+	if err != nil {
+		return fmt.Errorf("failed to marshal encoded value to json: %w", err)
+	}
+	return protojson.UnmarshalOptions{AllowPartial: true, DiscardUnknown: true}.Unmarshal(b, m)
+}
+
 var encodeProtoMessage = func(value interface{}) (interface{}, error) {
 	b, err := protojson.Marshal(value.(proto.Message))
 	if err != nil {
@@ -65,6 +79,11 @@ func init() {
 		encodeProtoMessage,
 		decodeJobExecutionStatus,
 	)
+	encoding.SetTypeEncoding(
+		reflect.TypeOf(new(frontierV1.CrawlExecutionStatus)),
+		encodeProtoMessage,
+		decodeCrawlExecutionStatus,
+	)
 	encoding.SetTypeEncoding(
 		reflect.TypeOf(map[string]interface{}{}),
 		func(value interface{}) (i interface{}, err error) {
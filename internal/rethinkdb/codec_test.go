@@ -2,9 +2,11 @@ package rethinkdb
 
 import (
 	"testing"
+	"time"
 
 	frontierV1 "github.com/nlnwa/veidemann-api/go/frontier/v1"
 	"google.golang.org/protobuf/encoding/protojson"
+	"gopkg.in/rethinkdb/rethinkdb-go.v6/encoding"
 )
 
 func TestUnmarshal(t *testing.T) {
@@ -40,3 +42,26 @@ func TestUnmarshal(t *testing.T) {
 	}
 
 }
+
+func TestDecodeCrawlExecutionStatus(t *testing.T) {
+	doc := map[string]interface{}{
+		"id":             "ces-1",
+		"jobExecutionId": "jes-1",
+		"seedId":         "seed-1",
+		"state":          "DIED",
+		"urisCrawled":    12,
+		"createdTime":    time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		"lastChangeTime": time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC),
+	}
+
+	ces := new(frontierV1.CrawlExecutionStatus)
+	if err := encoding.Decode(ces, doc); err != nil {
+		t.Fatal(err)
+	}
+	if ces.GetState() != frontierV1.CrawlExecutionStatus_DIED || ces.GetUrisCrawled() != 12 || ces.GetSeedId() != "seed-1" {
+		t.Errorf("unexpected crawl execution: %v", ces)
+	}
+	if got := ces.GetLastChangeTime().AsTime(); !got.Equal(time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC)) {
+		t.Errorf("lastChangeTime = %v", got)
+	}
+}
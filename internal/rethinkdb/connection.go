@@ -24,8 +24,9 @@ import (
 
 // connection holds the connection to RethinkDB.
 type connection struct {
-	opts    r.ConnectOpts
-	session *r.Session
+	opts r.ConnectOpts
+	// session is a *r.Session when connected, or a mock in tests.
+	session r.QueryExecutor
 }
 
 func NewConnection(host string, port int, username string, password string, database string, timeout time.Duration) *Query {
@@ -55,8 +56,8 @@ func (c *connection) Connect() error {
 
 // Close closes the database connection.
 func (c *connection) Close() error {
-	if c.session != nil {
-		return c.session.Close()
+	if session, ok := c.session.(*r.Session); ok {
+		return session.Close()
 	}
 	return nil
 }
@@ -0,0 +1,150 @@
+/*
+ * Copyright 2024 National Library of Norway.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rethinkdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	logV1 "github.com/nlnwa/veidemann-api/go/log/v1"
+	"github.com/rs/zerolog/log"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+// errUnknownJob is returned when the crawl job of a job execution is not found.
+var errUnknownJob = errors.New("crawl job not found")
+
+// crawlLogFields are the fields of crawl log entries read by FollowCrawlLog.
+var crawlLogFields = []interface{}{"jobExecutionId", "statusCode", "fetchTimeMs", "size", "contentType", "recordType"}
+
+// FollowCrawlLog calls fn with every entry written to the crawl log and the
+// name of the crawl job it belongs to, until ctx is done or the changefeed
+// fails. Entries written before the call are not included. Entries that
+// cannot be decoded or whose job name cannot be looked up are logged and
+// skipped, and dropped is called for each of them.
+func (qc *Query) FollowCrawlLog(ctx context.Context, fn func(job string, entry *logV1.CrawlLog), dropped func()) error {
+	return followLog(ctx, qc, "crawl_log", crawlLogFields, func(doc map[string]interface{}) (*logV1.CrawlLog, error) {
+		entry := new(logV1.CrawlLog)
+		return entry, unmarshalProto(doc, entry)
+	}, fn, dropped)
+}
+
+// pageLogFields are the fields of page log entries read by FollowPageLog.
+var pageLogFields = []interface{}{"jobExecutionId", "outlink", map[string]interface{}{"resource": "fromCache"}}
+
+// FollowPageLog calls fn with every entry written to the page log and the
+// name of the crawl job it belongs to, like FollowCrawlLog.
+func (qc *Query) FollowPageLog(ctx context.Context, fn func(job string, entry *logV1.PageLog), dropped func()) error {
+	return followLog(ctx, qc, "page_log", pageLogFields, func(doc map[string]interface{}) (*logV1.PageLog, error) {
+		entry := new(logV1.PageLog)
+		return entry, unmarshalProto(doc, entry)
+	}, fn, dropped)
+}
+
+// logEntry is an entry of a log table belonging to a job execution.
+type logEntry interface {
+	GetJobExecutionId() string
+}
+
+// followLog calls fn with every entry inserted into a log table, read with the
+// given fields and decoded by decode, and the name of the crawl job it belongs
+// to, until ctx is done or the changefeed fails. Entries that cannot be
+// decoded or whose job name cannot be looked up are logged and skipped, and
+// dropped is called for each of them.
+func followLog[T logEntry](ctx context.Context, qc *Query, table string, fields []interface{},
+	decode func(doc map[string]interface{}) (T, error), fn func(job string, entry T), dropped func()) error {
+	cursor, err := logInserts(table, fields).Run(qc.session, r.RunOpts{Context: ctx})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", table, err)
+	}
+	defer func() { _ = cursor.Close() }()
+
+	names := make(jobNames)
+	var c change
+	for cursor.Next(&c) {
+		entry, err := decode(c.NewVal)
+		c = change{}
+		if err != nil {
+			log.Warn().Err(err).Str("table", table).Msg("Skipping undecodable log entry")
+			dropped()
+			continue
+		}
+		job, err := names.lookup(ctx, qc, entry.GetJobExecutionId())
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Warn().Err(err).Str("table", table).Msg("Skipping log entry without job name")
+			dropped()
+			continue
+		}
+		fn(job, entry)
+	}
+	return cursor.Err()
+}
+
+// logInserts is a changefeed of the given fields of the entries inserted into
+// a log table.
+func logInserts(table string, fields []interface{}) r.Term {
+	return r.Table(table).
+		Pluck(fields...).
+		Changes().
+		Filter(func(c r.Term) r.Term {
+			return c.Field("old_val").Eq(nil)
+		})
+}
+
+// jobNames caches the crawl job names of job executions.
+type jobNames map[string]string
+
+// lookup returns the name of the crawl job of a job execution. It returns
+// errUnknownJob if the job execution or crawl job does not exist, which is not
+// cached, so the job execution is looked up again once it is written.
+func (n jobNames) lookup(ctx context.Context, qc *Query, jobExecutionId string) (string, error) {
+	if name, ok := n[jobExecutionId]; ok {
+		return name, nil
+	}
+	cursor, err := jobNameTerm(jobExecutionId).
+		Run(qc.session, r.RunOpts{
+			ReadMode: "outdated",
+			Context:  ctx,
+		})
+	if err != nil {
+		return "", fmt.Errorf("failed to get job name of job execution %s: %w", jobExecutionId, err)
+	}
+	var name string
+	if err := cursor.One(&name); err != nil {
+		return "", fmt.Errorf("failed to get job name of job execution %s: %w", jobExecutionId, err)
+	}
+	if name == "" {
+		return "", fmt.Errorf("failed to get job name of job execution %s: %w", jobExecutionId, errUnknownJob)
+	}
+	n[jobExecutionId] = name
+	return name, nil
+}
+
+// jobNameTerm is the name of the crawl job of a job execution, or the empty
+// string if the job execution or crawl job does not exist.
+func jobNameTerm(jobExecutionId string) r.Term {
+	return r.Table("job_executions").
+		Get(jobExecutionId).
+		Do(func(jes r.Term) r.Term {
+			return r.Table("config").Get(jes.Field("jobId")).Field("meta").Field("name")
+		}).
+		Default("")
+}
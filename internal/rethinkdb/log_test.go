@@ -0,0 +1,84 @@
+package rethinkdb
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	logV1 "github.com/nlnwa/veidemann-api/go/log/v1"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+func TestJobNamesLookup(t *testing.T) {
+	mock := r.NewMock()
+	mock.On(jobNameTerm("jes-1")).Return("daily", nil).Once()
+	mock.On(jobNameTerm("jes-2")).Return("", nil).Twice()
+	qc := &Query{connection: &connection{session: mock}}
+
+	names := make(jobNames)
+	for i := 0; i < 2; i++ {
+		if name, err := names.lookup(context.Background(), qc, "jes-1"); err != nil || name != "daily" {
+			t.Errorf("lookup of jes-1 = %q, %v, want daily", name, err)
+		}
+		// Unknown jobs are looked up again
+		if name, err := names.lookup(context.Background(), qc, "jes-2"); !errors.Is(err, errUnknownJob) {
+			t.Errorf("lookup of jes-2 = %q, %v, want %v", name, err, errUnknownJob)
+		}
+	}
+	mock.AssertExpectations(t)
+}
+
+func TestFollowCrawlLogDropsEntriesOfUnknownJobs(t *testing.T) {
+	mock := r.NewMock()
+	mock.On(logInserts("crawl_log", crawlLogFields)).
+		Return([]interface{}{
+			map[string]interface{}{"new_val": map[string]interface{}{"jobExecutionId": "jes-1", "statusCode": 200}},
+			map[string]interface{}{"new_val": map[string]interface{}{"jobExecutionId": "jes-2", "statusCode": 200}},
+			map[string]interface{}{"new_val": map[string]interface{}{"jobExecutionId": "jes-1", "statusCode": 404}},
+		}, nil)
+	mock.On(jobNameTerm("jes-1")).Return("daily", nil)
+	mock.On(jobNameTerm("jes-2")).Return("", nil)
+	qc := &Query{connection: &connection{session: mock}}
+
+	var jobs []string
+	dropped := 0
+	err := qc.FollowCrawlLog(context.Background(), func(job string, _ *logV1.CrawlLog) {
+		jobs = append(jobs, job)
+	}, func() { dropped++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || jobs[0] != "daily" || jobs[1] != "daily" {
+		t.Errorf("expected two entries of daily, got %v", jobs)
+	}
+	if dropped != 1 {
+		t.Errorf("expected one dropped entry, got %d", dropped)
+	}
+}
+
+func TestFollowPageLogDropsEntriesOfUnknownJobs(t *testing.T) {
+	mock := r.NewMock()
+	mock.On(logInserts("page_log", pageLogFields)).
+		Return([]interface{}{
+			map[string]interface{}{"new_val": map[string]interface{}{"jobExecutionId": "jes-1", "outlink": []string{"http://example.com/"}}},
+			map[string]interface{}{"new_val": map[string]interface{}{"jobExecutionId": "jes-2"}},
+		}, nil)
+	mock.On(jobNameTerm("jes-1")).Return("daily", nil)
+	mock.On(jobNameTerm("jes-2")).Return("", nil)
+	qc := &Query{connection: &connection{session: mock}}
+
+	var jobs []string
+	dropped := 0
+	err := qc.FollowPageLog(context.Background(), func(job string, _ *logV1.PageLog) {
+		jobs = append(jobs, job)
+	}, func() { dropped++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0] != "daily" {
+		t.Errorf("expected one entry of daily, got %v", jobs)
+	}
+	if dropped != 1 {
+		t.Errorf("expected one dropped entry, got %d", dropped)
+	}
+}
@@ -2,10 +2,13 @@ package rethinkdb
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/nlnwa/veidemann-api/go/frontier/v1"
+	"golang.org/x/sync/errgroup"
 	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
 	"sort"
+	"time"
 )
 
 type Query struct {
@@ -66,41 +69,315 @@ func contains(list []string, item ...string) bool {
 	return true
 }
 
+// latestJobExecutionConcurrency limits the number of concurrent lookups of the
+// latest execution of a crawl job.
+const latestJobExecutionConcurrency = 16
+
+// WalkLatestJobExecutionForCrawlJobs calls fn with the latest job execution of
+// every crawl job, with the jobId replaced by the name of the crawl job.
+//
+// The latest execution of each job is a cheap lookup in the jobId_startTime
+// index, so the cost does not grow with the history of the jobs. RethinkDB
+// evaluates subqueries of a single query sequentially, so the lookups are run
+// as concurrent queries instead.
 func (qc *Query) WalkLatestJobExecutionForCrawlJobs(ctx context.Context, fn func(*frontier.JobExecutionStatus)) error {
-	cursor, err := r.Table("config").Filter(map[string]interface{}{"kind": "crawlJob"}).
-		Map(func(job r.Term) interface{} {
-			return r.Table("job_executions").
-				OrderBy(r.OrderByOpts{Index: r.Desc("jobId_startTime")}).
-				Between([]r.Term{job.Field("id"), r.MinVal}, []r.Term{job.Field("id"), r.MaxVal}).
-				Limit(1).
-				Map(func(jes r.Term) interface{} {
-					return jes.Merge(map[string]interface{}{
-						"executionsState": jes.Field("executionsState").
-							ConcatMap(func(state r.Term) interface{} {
-								return state.CoerceTo("array")
-							}).
-							CoerceTo("object"),
-						"jobId": job.Field("meta").Field("name"),
-					})
-				}).
-				Nth(0).
-				Default(nil)
+	jobs, err := qc.listCrawlJobs(ctx)
+	if err != nil {
+		return err
+	}
+
+	latest := make([]*frontier.JobExecutionStatus, len(jobs))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(latestJobExecutionConcurrency)
+	for i, job := range jobs {
+		i, job := i, job
+		g.Go(func() error {
+			jes, err := qc.latestJobExecution(gctx, job.Id)
+			if err != nil {
+				return fmt.Errorf("failed to get latest execution of job %s: %w", job.Meta.Name, err)
+			}
+			if jes != nil {
+				jes.JobId = job.Meta.Name
+			}
+			latest[i] = jes
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	for _, jes := range latest {
+		if jes != nil {
+			fn(jes)
+		}
+	}
+	return nil
+}
+
+// latestJobExecution returns the job execution of a crawl job with the latest
+// start time, or nil if the job has no executions.
+func (qc *Query) latestJobExecution(ctx context.Context, jobId string) (*frontier.JobExecutionStatus, error) {
+	cursor, err := r.Table("job_executions").
+		Between([]interface{}{jobId, r.MinVal}, []interface{}{jobId, r.MaxVal}, r.BetweenOpts{Index: "jobId_startTime"}).
+		OrderBy(r.OrderByOpts{Index: r.Desc("jobId_startTime")}).
+		Limit(1).
+		Map(normalizeJobExecution).
+		Run(qc.session, r.RunOpts{
+			ReadMode: "outdated",
+			Context:  ctx,
+		})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = cursor.Close() }()
+
+	jes := new(frontier.JobExecutionStatus)
+	if !cursor.Next(jes) {
+		return nil, cursor.Err()
+	}
+	return jes, nil
+}
+
+// WalkLatestEndedJobExecutions calls fn with the job execution of every crawl
+// job that ended last in each of the given states, with the jobId replaced by
+// the name of the crawl job. Executions without an end time are left out.
+func (qc *Query) WalkLatestEndedJobExecutions(ctx context.Context, states []frontier.JobExecutionStatus_State, fn func(*frontier.JobExecutionStatus)) error {
+	names := make([]string, 0, len(states))
+	for _, state := range states {
+		names = append(names, state.String())
+	}
+	term := r.Table("job_executions").Filter(func(jes r.Term) r.Term {
+		return r.Expr(names).Contains(jes.Field("state"))
+	})
+	return qc.walkLatestJobExecutions(ctx, term, "endTime", []interface{}{"jobId", "state"}, fn)
+}
+
+// walkLatestJobExecutions calls fn with the job execution with the greatest
+// value of field in every group of the job executions selected by term,
+// grouped by the given fields, with the jobId replaced by the name of the
+// crawl job. Executions without the field and executions of deleted crawl
+// jobs are left out.
+//
+// This is a single query: the servers holding the job executions reduce every
+// group to the id of its latest execution, so only the selected executions
+// are read in full. It scans all executions selected by term, so it suits
+// collectors updated rarely.
+func (qc *Query) walkLatestJobExecutions(ctx context.Context, term r.Term, field string, group []interface{}, fn func(*frontier.JobExecutionStatus)) error {
+	cursor, err := term.
+		HasFields(field).
+		Pluck(append([]interface{}{"id", field}, group...)...).
+		Group(group...).
+		Max(field).
+		Ungroup().
+		Map(func(g r.Term) interface{} {
+			return g.Field("reduction").Field("id")
 		}).
-		Filter(func(jes r.Term) r.Term {
-			return jes.Eq(nil).Not()
+		Do(func(ids r.Term) interface{} {
+			return r.Table("job_executions").GetAll(r.Args(ids))
 		}).
+		EqJoin("jobId", r.Table("config")).
+		Map(namedJobExecution).
 		Run(qc.session, r.RunOpts{
-			Durability: "soft",
-			ReadMode:   "outdated",
-			Context:    ctx,
+			ReadMode: "outdated",
+			Context:  ctx,
 		})
 	if err != nil {
 		return err
 	}
+	defer func() { _ = cursor.Close() }()
 
 	jes := new(frontier.JobExecutionStatus)
 	for cursor.Next(jes) {
 		fn(jes)
+		jes = new(frontier.JobExecutionStatus)
 	}
 	return cursor.Err()
 }
+
+// listCrawlJobs returns the id and name of every crawl job.
+func (qc *Query) listCrawlJobs(ctx context.Context) ([]crawlJob, error) {
+	cursor, err := r.Table("config").
+		Filter(map[string]interface{}{"kind": "crawlJob"}).
+		Pluck("id", map[string]interface{}{"meta": "name"}).
+		Run(qc.session, r.RunOpts{
+			ReadMode: "outdated",
+			Context:  ctx,
+		})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = cursor.Close() }()
+
+	var jobs []crawlJob
+	if err := cursor.All(&jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// WalkCrawlExecutions calls fn with every crawl execution of a job execution,
+// looked up in the jobExecutionId_seqNo index of the executions table.
+func (qc *Query) WalkCrawlExecutions(ctx context.Context, jobExecutionId string, fn func(*frontier.CrawlExecutionStatus)) error {
+	cursor, err := r.Table("executions").
+		Between([]interface{}{jobExecutionId, r.MinVal}, []interface{}{jobExecutionId, r.MaxVal}, r.BetweenOpts{Index: "jobExecutionId_seqNo"}).
+		Run(qc.session, r.RunOpts{
+			ReadMode: "outdated",
+			Context:  ctx,
+		})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cursor.Close() }()
+
+	ces := new(frontier.CrawlExecutionStatus)
+	for cursor.Next(ces) {
+		fn(ces)
+		ces = new(frontier.CrawlExecutionStatus)
+	}
+	return cursor.Err()
+}
+
+// LastCrawlExecutionChange returns the latest time a crawl execution of a job
+// execution changed, or the zero time if it has no crawl executions.
+func (qc *Query) LastCrawlExecutionChange(ctx context.Context, jobExecutionId string) (time.Time, error) {
+	cursor, err := r.Table("executions").
+		Between([]interface{}{jobExecutionId, r.MinVal}, []interface{}{jobExecutionId, r.MaxVal}, r.BetweenOpts{Index: "jobExecutionId_seqNo"}).
+		Max("lastChangeTime").
+		Field("lastChangeTime").
+		Default(nil).
+		Run(qc.session, r.RunOpts{
+			ReadMode: "outdated",
+			Context:  ctx,
+		})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var lastChange time.Time
+	if err := cursor.One(&lastChange); err != nil && !errors.Is(err, r.ErrEmptyResult) {
+		return time.Time{}, err
+	}
+	return lastChange, nil
+}
+
+// jobExecutionCount is the number of job executions of a crawl job in a state,
+// grouped by jobId and state.
+type jobExecutionCount struct {
+	Group     []string `rethinkdb:"group"`
+	Reduction int64    `rethinkdb:"reduction"`
+}
+
+// CountJobExecutions calls fn with the number of job executions of every crawl
+// job in each of the given states, counting only executions started at or
+// after since unless it is zero. Executions of deleted crawl jobs are not
+// counted. This scans the whole job_executions table.
+func (qc *Query) CountJobExecutions(ctx context.Context, states []frontier.JobExecutionStatus_State, since time.Time, fn func(job string, state frontier.JobExecutionStatus_State, count int64)) error {
+	jobs, err := qc.listCrawlJobs(ctx)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]string, len(jobs))
+	for _, job := range jobs {
+		names[job.Id] = job.Meta.Name
+	}
+
+	stateNames := make([]string, 0, len(states))
+	for _, state := range states {
+		stateNames = append(stateNames, state.String())
+	}
+	term := r.Table("job_executions").
+		Filter(func(jes r.Term) r.Term {
+			return r.Expr(stateNames).Contains(jes.Field("state"))
+		})
+	if !since.IsZero() {
+		term = term.Filter(func(jes r.Term) r.Term {
+			return jes.Field("startTime").Ge(since)
+		})
+	}
+	cursor, err := term.
+		Group("jobId", "state").
+		Count().
+		Ungroup().
+		Run(qc.session, r.RunOpts{
+			ReadMode: "outdated",
+			Context:  ctx,
+		})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cursor.Close() }()
+
+	var c jobExecutionCount
+	for cursor.Next(&c) {
+		if len(c.Group) == 2 {
+			if name, ok := names[c.Group[0]]; ok {
+				fn(name, frontier.JobExecutionStatus_State(frontier.JobExecutionStatus_State_value[c.Group[1]]), c.Reduction)
+			}
+		}
+		c = jobExecutionCount{}
+	}
+	return cursor.Err()
+}
+
+// WalkRunningJobExecutions calls fn with every job execution that has not
+// reached a terminal state, with the jobId replaced by the name of the crawl job.
+// The executions are looked up in the state index of the job_executions table,
+// so the cost does not grow with the history.
+func (qc *Query) WalkRunningJobExecutions(ctx context.Context, fn func(*frontier.JobExecutionStatus)) error {
+	cursor, err := r.Table("job_executions").
+		GetAllByIndex("state", r.Args(runningJobExecutionStates)).
+		EqJoin("jobId", r.Table("config")).
+		Map(namedJobExecution).
+		Run(qc.session, r.RunOpts{
+			ReadMode: "outdated",
+			Context:  ctx,
+		})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cursor.Close() }()
+
+	jes := new(frontier.JobExecutionStatus)
+	for cursor.Next(jes) {
+		fn(jes)
+		jes = new(frontier.JobExecutionStatus)
+	}
+	return cursor.Err()
+}
+
+// runningJobExecutionStates are the states of job executions that have not terminated.
+var runningJobExecutionStates = []string{
+	frontier.JobExecutionStatus_CREATED.String(),
+	frontier.JobExecutionStatus_RUNNING.String(),
+}
+
+// isRunning reports whether a job execution has not terminated.
+func isRunning(jes *frontier.JobExecutionStatus) bool {
+	switch jes.GetState() {
+	case frontier.JobExecutionStatus_CREATED, frontier.JobExecutionStatus_RUNNING:
+		return true
+	default:
+		return false
+	}
+}
+
+// namedJobExecution turns a job execution joined with its crawl job into a
+// normalized job execution with the jobId replaced by the name of the crawl job.
+func namedJobExecution(row r.Term) interface{} {
+	return normalizeJobExecution(row.Field("left")).Merge(map[string]interface{}{
+		"jobId": row.Field("right").Field("meta").Field("name"),
+	})
+}
+
+// normalizeJobExecution turns the executionsState of a stored job execution,
+// which is a list of single entry objects, into a single object.
+func normalizeJobExecution(jes r.Term) r.Term {
+	return jes.Merge(map[string]interface{}{
+		"executionsState": jes.Field("executionsState").
+			ConcatMap(func(state r.Term) interface{} {
+				return state.CoerceTo("array")
+			}).
+			CoerceTo("object"),
+	})
+}
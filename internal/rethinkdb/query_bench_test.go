@@ -0,0 +1,186 @@
+package rethinkdb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nlnwa/veidemann-api/go/frontier/v1"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+// The benchmarks need a RethinkDB server given by RETHINKDB_ADDRESS (host:port)
+// and seed the database veidemann_bench with a synthetic dataset on first run:
+//
+//	RETHINKDB_ADDRESS=localhost:28015 go test -run ^$ -bench LatestJobExecution ./internal/rethinkdb
+const (
+	benchDatabase         = "veidemann_bench"
+	benchJobs             = 2000
+	benchExecutionsPerJob = 100
+)
+
+func benchQuery(b *testing.B) *Query {
+	b.Helper()
+	address := os.Getenv("RETHINKDB_ADDRESS")
+	if address == "" {
+		b.Skip("RETHINKDB_ADDRESS is not set")
+	}
+	session, err := r.Connect(r.ConnectOpts{Address: address, Database: benchDatabase, Timeout: time.Minute})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = session.Close() })
+	if err := seedBenchDatabase(session); err != nil {
+		b.Fatal(err)
+	}
+	return &Query{connection: &connection{session: session}}
+}
+
+func seedBenchDatabase(session *r.Session) error {
+	var dbs []string
+	if err := r.DBList().ReadAll(&dbs, session); err != nil {
+		return err
+	}
+	if contains(dbs, benchDatabase) {
+		return nil
+	}
+	if err := r.DBCreate(benchDatabase).Exec(session); err != nil {
+		return err
+	}
+	for _, table := range []string{"config", "job_executions"} {
+		if err := r.DB(benchDatabase).TableCreate(table).Exec(session); err != nil {
+			return err
+		}
+	}
+	jobExecutions := r.DB(benchDatabase).Table("job_executions")
+	if err := jobExecutions.IndexCreateFunc("jobId_startTime", func(jes r.Term) interface{} {
+		return []interface{}{jes.Field("jobId"), jes.Field("startTime")}
+	}).Exec(session); err != nil {
+		return err
+	}
+	if err := jobExecutions.IndexCreate("state").Exec(session); err != nil {
+		return err
+	}
+	if err := jobExecutions.IndexWait().Exec(session); err != nil {
+		return err
+	}
+
+	jobs := make([]interface{}, 0, benchJobs)
+	for j := 0; j < benchJobs; j++ {
+		jobs = append(jobs, map[string]interface{}{
+			"id":   fmt.Sprintf("job-%d", j),
+			"kind": "crawlJob",
+			"meta": map[string]interface{}{"name": fmt.Sprintf("Job %d", j)},
+		})
+	}
+	if err := r.DB(benchDatabase).Table("config").Insert(jobs).Exec(session); err != nil {
+		return err
+	}
+
+	start := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	for j := 0; j < benchJobs; j++ {
+		executions := make([]interface{}, 0, benchExecutionsPerJob)
+		for e := 0; e < benchExecutionsPerJob; e++ {
+			state := "FINISHED"
+			if e == benchExecutionsPerJob-1 && j%10 == 0 {
+				state = "RUNNING"
+			}
+			executions = append(executions, map[string]interface{}{
+				"jobId":     fmt.Sprintf("job-%d", j),
+				"state":     state,
+				"startTime": start.Add(time.Duration(e) * 24 * time.Hour),
+				"executionsState": []interface{}{
+					map[string]interface{}{"FETCHING": 0},
+					map[string]interface{}{"FINISHED": 10},
+				},
+				"urisCrawled":  1000,
+				"bytesCrawled": 1 << 20,
+			})
+		}
+		if err := jobExecutions.Insert(executions).Exec(session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkLatestJobExecutionSubqueries is the previous implementation, which
+// evaluates one subquery per crawl job within a single query.
+func walkLatestJobExecutionSubqueries(ctx context.Context, qc *Query, fn func(*frontier.JobExecutionStatus)) error {
+	cursor, err := r.Table("config").Filter(map[string]interface{}{"kind": "crawlJob"}).
+		Map(func(job r.Term) interface{} {
+			return r.Table("job_executions").
+				OrderBy(r.OrderByOpts{Index: r.Desc("jobId_startTime")}).
+				Between([]r.Term{job.Field("id"), r.MinVal}, []r.Term{job.Field("id"), r.MaxVal}).
+				Limit(1).
+				Map(func(jes r.Term) interface{} {
+					return normalizeJobExecution(jes).Merge(map[string]interface{}{
+						"jobId": job.Field("meta").Field("name"),
+					})
+				}).
+				Nth(0).
+				Default(nil)
+		}).
+		Filter(func(jes r.Term) r.Term {
+			return jes.Eq(nil).Not()
+		}).
+		Run(qc.session, r.RunOpts{ReadMode: "outdated", Context: ctx})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cursor.Close() }()
+
+	jes := new(frontier.JobExecutionStatus)
+	for cursor.Next(jes) {
+		fn(jes)
+	}
+	return cursor.Err()
+}
+
+func benchmarkWalk(b *testing.B, walk func(context.Context, *Query, func(*frontier.JobExecutionStatus)) error) {
+	qc := benchQuery(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n := 0
+		if err := walk(context.Background(), qc, func(*frontier.JobExecutionStatus) { n++ }); err != nil {
+			b.Fatal(err)
+		}
+		if n != benchJobs {
+			b.Fatalf("expected %d job executions, got %d", benchJobs, n)
+		}
+	}
+}
+
+func BenchmarkLatestJobExecutionSubqueries(b *testing.B) {
+	benchmarkWalk(b, walkLatestJobExecutionSubqueries)
+}
+
+func BenchmarkLatestJobExecutionConcurrentLookups(b *testing.B) {
+	benchmarkWalk(b, func(ctx context.Context, qc *Query, fn func(*frontier.JobExecutionStatus)) error {
+		return qc.WalkLatestJobExecutionForCrawlJobs(ctx, fn)
+	})
+}
+
+// BenchmarkLatestJobExecutionGrouped groups the whole job_executions table by
+// job in a single query instead of looking up every job in the index.
+func BenchmarkLatestJobExecutionGrouped(b *testing.B) {
+	benchmarkWalk(b, func(ctx context.Context, qc *Query, fn func(*frontier.JobExecutionStatus)) error {
+		return qc.walkLatestJobExecutions(ctx, r.Table("job_executions"), "startTime", []interface{}{"jobId"}, fn)
+	})
+}
+
+func BenchmarkRunningJobExecutions(b *testing.B) {
+	qc := benchQuery(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n := 0
+		if err := qc.WalkRunningJobExecutions(context.Background(), func(*frontier.JobExecutionStatus) { n++ }); err != nil {
+			b.Fatal(err)
+		}
+		if n != benchJobs/10 {
+			b.Fatalf("expected %d job executions, got %d", benchJobs/10, n)
+		}
+	}
+}
@@ -17,19 +17,22 @@
 package main
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/nlnwa/veidemann-metrics/internal/frontier"
 	"github.com/nlnwa/veidemann-metrics/internal/logger"
 	"github.com/nlnwa/veidemann-metrics/internal/metrics"
+	"github.com/nlnwa/veidemann-metrics/internal/probe"
 	"github.com/nlnwa/veidemann-metrics/internal/rethinkdb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors/version"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/pflag"
@@ -48,29 +51,10 @@ const indexContent = `<!DOCTYPE html>
 </html>`
 
 func main() {
-	pflag.String("host", "", "Host")
-	pflag.Int("port", 9301, "Port")
-
-	pflag.String("db-host", "rethinkdb-proxy", "Database host")
-	pflag.Int("db-port", 28015, "Database port")
-	pflag.String("db-name", "veidemann", "Database name")
-	pflag.String("db-username", "admin", "Database username")
-	pflag.String("db-password", "", "Database password")
-
-	pflag.String("frontier-host", "veidemann-frontier", "Frontier host")
-	pflag.Int("frontier-port", 7700, "Frontier port")
-
-	pflag.String("log-level", "info", "Log level; available levels are panic, fatal, error, warn, info, debug and trace")
-	pflag.String("log-formatter", "logfmt", "Log formatter; available values are logfmt and json")
-	pflag.Bool("log-method", false, "Log method names or not")
-
+	registerFlags(pflag.CommandLine)
 	pflag.Parse()
 
-	_ = viper.BindPFlags(pflag.CommandLine)
-	replacer := strings.NewReplacer("-", "_")
-	viper.SetEnvKeyReplacer(replacer)
-	viper.AutomaticEnv()
-	if err := viper.BindPFlags(pflag.CommandLine); err != nil {
+	if err := bindConfig(viper.GetViper(), pflag.CommandLine); err != nil {
 		log.Fatal().Err(err).Msg("Failed to parse flags")
 	}
 
@@ -89,7 +73,6 @@ func main() {
 			Int("port", viper.GetInt("db-port")).
 			Msg("Failed to connect to RethinkDB")
 	}
-	defer func() { _ = db.Close() }()
 	log.Info().
 		Str("host", viper.GetString("db-host")).
 		Int("port", viper.GetInt("db-port")).
@@ -105,32 +88,116 @@ func main() {
 	if err != nil {
 		log.Fatal().Err(err).Str("address", frontierAddress).Msg("Failed to create frontier client")
 	}
-	defer conn.Close()
-
 	log.Info().Str("address", frontierAddress).Msg("Frontier channel created")
 
-	exp := metrics.New(db, frontier.New(conn))
-	exp.Run(30 * time.Second)
+	collectors := collectorConfigs(viper.GetViper())
+	for _, cfg := range collectors {
+		log.Info().Str("collector", cfg.Name).Dur("interval", cfg.Interval).Dur("timeout", cfg.Timeout).Str("mode", string(cfg.Mode)).Msg("Enabled collector")
+	}
+
+	var jobSource metrics.JobExecutionSource = db
+	var watcher *rethinkdb.JobExecutionWatcher
+	if viper.GetBool(collectorKey("jobs", "changefeed")) {
+		watcher = rethinkdb.NewJobExecutionWatcher(db)
+		jobSource = watcher
+		log.Info().Msg("Following job executions by changefeed")
+	}
+
+	options := collectorOptions(viper.GetViper())
+
+	sources := metrics.Sources{
+		JobExecutions:   jobSource,
+		JobHistory:      db,
+		CrawlExecutions: db,
+		CrawlLog:        db,
+		PageLog:         db,
+		Frontier:        frontier.New(conn),
+	}
+	exp, err := metrics.New(sources, options, collectors...)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create exporter")
+	}
+	prometheus.MustRegister(version.NewCollector("veidemann_exporter"), exp)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Queries shared by scrapes and probes outlive the scrape that started
+	// them, and are canceled before connections are closed
+	queryCtx, cancelQueries := context.WithCancel(context.Background())
+	defer cancelQueries()
+	cache := metrics.NewCache(queryCtx)
+	exp.SetCache(cache)
+
+	collecting := make(chan struct{})
+	go func() {
+		defer close(collecting)
+		var wg sync.WaitGroup
+		if watcher != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				watcher.Run(ctx)
+			}()
+		}
+		exp.Run(ctx)
+		wg.Wait()
+	}()
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte(indexContent))
 	})
-	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/metrics", exp.ScrapeHandler(promhttp.Handler()))
+
+	var prober *probe.Handler
+	if targetsFile := viper.GetString("targets-file"); targetsFile != "" {
+		targets, err := probe.LoadConfig(targetsFile)
+		if err != nil {
+			log.Fatal().Err(err).Str("file", targetsFile).Msg("Failed to load targets")
+		}
+		prober = probe.NewHandler(queryCtx, targets, options, collectors)
+		http.Handle("/probe", prober)
+		log.Info().Int("targets", len(targets)).Msg("Probe endpoint enabled")
+	}
 	addr := fmt.Sprintf("%s:%d", viper.GetString("host"), viper.GetInt("port"))
 	server := &http.Server{Addr: addr}
 
+	serving := make(chan error, 1)
 	go func() {
-		signals := make(chan os.Signal, 2)
-		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
-		sig := <-signals
-		log.Info().Str("signal", sig.String()).Msg("Shutting down")
-		_ = server.Close()
+		// Serve metrics
+		log.Info().Str("address", addr).Msg("Server listening")
+		serving <- server.ListenAndServe()
 	}()
 
-	// Serve metrics
-	log.Info().Str("address", addr).Msg("Server listening")
-	err = server.ListenAndServe()
-	if errors.Is(err, http.ErrServerClosed) {
-		log.Err(err).Msg("")
+	select {
+	case <-ctx.Done():
+		log.Info().Msg("Shutting down")
+	case err := <-serving:
+		log.Error().Err(err).Msg("Server failed, shutting down")
+		stop()
+	}
+
+	// Stop accepting new connections and wait for in-flight scrapes to finish
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), viper.GetDuration("shutdown-timeout"))
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Warn().Err(err).Msg("Failed to drain in-flight requests")
+	}
+
+	// Wait for collectors, changefeeds and shared queries to abort before closing connections
+	cancelQueries()
+	<-collecting
+	cache.Close()
+	if err := conn.Close(); err != nil {
+		log.Warn().Err(err).Msg("Failed to close frontier connection")
+	}
+	if err := db.Close(); err != nil {
+		log.Warn().Err(err).Msg("Failed to close RethinkDB session")
+	}
+	if prober != nil {
+		if err := prober.Close(); err != nil {
+			log.Warn().Err(err).Msg("Failed to close probe target connections")
+		}
 	}
+	log.Info().Msg("Shutdown complete")
 }